@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const (
+	envKubebuilderAssets = "KUBEBUILDER_ASSETS"
+)
+
+// requiredBinaries are the binaries that have to be present in an envtest binary assets directory.
+var requiredBinaries = []string{"etcd", "kube-apiserver"}
+
+// binaryAssets is a local directory containing the envtest binaries of a Kubernetes version.
+type binaryAssets struct {
+	// Version is the Kubernetes version of the binaries. It is nil if the version could not be determined.
+	Version *semver.Version
+	// Directory is the directory containing the binaries.
+	Directory string
+}
+
+// binaryAssetsResolver locates envtest binaries on the local machine without using the network.
+type binaryAssetsResolver struct {
+	// KubebuilderAssets is the value of the KUBEBUILDER_ASSETS environment variable.
+	KubebuilderAssets string
+	// BinDir is a setup-envtest binary directory, containing the binaries in k8s/<version>-<os>-<arch> folders.
+	BinDir string
+}
+
+// defaultEnvtestBinDir returns the directory setup-envtest stores its binaries in by default.
+func defaultEnvtestBinDir() string {
+	dir, err := envtest.SetupEnvtestDefaultBinaryAssetsDirectory()
+	if err != nil {
+		return ""
+	}
+	return filepath.Dir(dir)
+}
+
+func hasRequiredBinaries(dir string) bool {
+	for _, binary := range requiredBinaries {
+		if _, err := os.Stat(filepath.Join(dir, binary)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBinaryAssetsDirectoryVersion parses the version of a setup-envtest binary directory name
+// (<version>-<os>-<arch>).
+func parseBinaryAssetsDirectoryVersion(dir string) (*semver.Version, bool) {
+	name := filepath.Base(filepath.Clean(dir))
+	suffix := fmt.Sprintf("-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if !strings.HasSuffix(name, suffix) {
+		return nil, false
+	}
+
+	version, err := semver.StrictNewVersion(strings.TrimPrefix(strings.TrimSuffix(name, suffix), "v"))
+	if err != nil {
+		return nil, false
+	}
+	return version, true
+}
+
+// available lists the locally available binary assets, starting with KUBEBUILDER_ASSETS
+// followed by the setup-envtest binary directory, newest versions first.
+func (r *binaryAssetsResolver) available() ([]binaryAssets, error) {
+	var res []binaryAssets
+	if r.KubebuilderAssets != "" && hasRequiredBinaries(r.KubebuilderAssets) {
+		version, _ := parseBinaryAssetsDirectoryVersion(r.KubebuilderAssets)
+		res = append(res, binaryAssets{Version: version, Directory: r.KubebuilderAssets})
+	}
+
+	if r.BinDir == "" {
+		return res, nil
+	}
+
+	k8sDir := filepath.Join(r.BinDir, "k8s")
+	entries, err := os.ReadDir(k8sDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return res, nil
+		}
+		return nil, fmt.Errorf("error reading envtest binary directory %s: %w", k8sDir, err)
+	}
+
+	var stored []binaryAssets
+	for _, entry := range entries {
+		dir := filepath.Join(k8sDir, entry.Name())
+		if !entry.IsDir() || !hasRequiredBinaries(dir) {
+			continue
+		}
+
+		version, ok := parseBinaryAssetsDirectoryVersion(dir)
+		if !ok {
+			continue
+		}
+		stored = append(stored, binaryAssets{Version: version, Directory: dir})
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].Version.GreaterThan(stored[j].Version)
+	})
+	return append(res, stored...), nil
+}
+
+// Resolve returns the binary assets of the newest locally available version matching the given
+// semver constraint. If the constraint is empty, KUBEBUILDER_ASSETS is preferred and the newest
+// installed version is used otherwise.
+func (r *binaryAssetsResolver) Resolve(constraint string) (*binaryAssets, error) {
+	available, err := r.available()
+	if err != nil {
+		return nil, err
+	}
+
+	if constraint == "" {
+		if len(available) == 0 {
+			return nil, fmt.Errorf("no envtest binaries found locally, set %s, pass --binary-assets-dir or install them via setup-envtest into %s",
+				envKubebuilderAssets, r.BinDir)
+		}
+		return &available[0], nil
+	}
+
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubernetes version constraint %q: %w", constraint, err)
+	}
+
+	var (
+		match    *binaryAssets
+		versions []string
+	)
+	for i := range available {
+		assets := &available[i]
+		if assets.Version == nil {
+			continue
+		}
+		if version := assets.Version.String(); !slices.Contains(versions, version) {
+			versions = append(versions, version)
+		}
+		if !c.Check(assets.Version) {
+			continue
+		}
+		if match == nil || assets.Version.GreaterThan(match.Version) {
+			match = assets
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("no envtest binaries matching %q found locally, available versions: %v", constraint, versions)
+	}
+	return match, nil
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ironcore-dev/openapi-extractor/envtestutils/apiserver"
+	flag "github.com/spf13/pflag"
+)
+
+func defaultBuildCacheDir() string {
+	dir, err := apiserver.DefaultBuildCacheDir()
+	if err != nil {
+		return ""
+	}
+	return dir
+}
+
+// buildCacheMaxAge is the duration after which unused entries are pruned from the build cache.
+const buildCacheMaxAge = 7 * 24 * time.Hour
+
+// pruneBuildCache removes the entries of the build cache not used within buildCacheMaxAge, so that the cache
+// does not grow indefinitely.
+func pruneBuildCache() {
+	if !useBuildCache || buildCacheDir == "" {
+		return
+	}
+	pruned, err := (&apiserver.BuildCache{Dir: buildCacheDir}).Prune(buildCacheMaxAge)
+	if err != nil {
+		log.Error(err, "failed to prune build cache", "BuildCacheDirectory", buildCacheDir)
+		return
+	}
+	if len(pruned) > 0 {
+		log.Info("Pruned unused build cache entries", "Count", len(pruned), "MaxAge", buildCacheMaxAge)
+	}
+}
+
+// runCacheCommand implements the `cache` command to inspect and prune the api server build cache.
+func runCacheCommand(args []string) error {
+	fs := flag.NewFlagSet("cache", flag.ContinueOnError)
+	cacheDir := defaultBuildCacheDir()
+	maxAge := buildCacheMaxAge
+	all := false
+	fs.StringVar(&cacheDir, "cache-dir", cacheDir, "Directory of the api server build cache")
+	fs.DurationVar(&maxAge, "max-age", maxAge, "prune: Remove entries not used within this duration")
+	fs.BoolVar(&all, "all", all, "prune: Remove all entries")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: openapi-extractor cache <list|prune> [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one of list, prune")
+	}
+	if cacheDir == "" {
+		return fmt.Errorf("must specify --cache-dir")
+	}
+
+	cache := &apiserver.BuildCache{Dir: cacheDir}
+	switch fs.Arg(0) {
+	case "list":
+		entries, err := cache.List()
+		if err != nil {
+			return err
+		}
+		printBuildCacheEntries(entries)
+		return nil
+	case "prune":
+		if all {
+			maxAge = 0
+		}
+		pruned, err := cache.Prune(maxAge)
+		if err != nil {
+			return err
+		}
+		var size int64
+		for _, entry := range pruned {
+			size += entry.Size
+		}
+		fmt.Printf("Pruned %d entries (%s)\n", len(pruned), formatSize(size))
+		return nil
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown cache command %q", fs.Arg(0))
+	}
+}
+
+func printBuildCacheEntries(entries []apiserver.BuildCacheEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "KEY\tPACKAGE\tGO\tSIZE\tCREATED\tLAST USED")
+	for _, entry := range entries {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			shortKey(entry.Key),
+			entry.MainPath,
+			entry.GoVersion,
+			formatSize(entry.Size),
+			entry.Created.Format(time.RFC3339),
+			entry.LastUsed.Format(time.RFC3339),
+		)
+	}
+	_ = w.Flush()
+}
+
+// shortKey abbreviates a build cache key like git abbreviates commit hashes.
+func shortKey(key string) string {
+	if len(key) > 12 {
+		return key[:12]
+	}
+	return key
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
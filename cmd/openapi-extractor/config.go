@@ -0,0 +1,610 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ironcore-dev/openapi-extractor/envtestutils/apiserver"
+	flag "github.com/spf13/pflag"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultConfigFile = "openapi-extractor.yaml"
+
+	defaultTargetName = "default"
+)
+
+// config is the declarative configuration of the openapi-extractor.
+type config struct {
+	// Targets are the extraction targets.
+	Targets []target `json:"targets"`
+}
+
+// target describes how to extract the OpenAPI specs of a single aggregated api server.
+type target struct {
+	// Name identifies the target.
+	Name string `json:"name"`
+
+	// APIServer configures how to build and run the aggregated api server.
+	APIServer apiServerConfig `json:"apiServer"`
+
+	// APIServices are paths to APIService definition files, directories or kustomization directories.
+	APIServices []string `json:"apiServices,omitempty"`
+
+	// Servers are several aggregated api servers registered in the same control plane and extracted
+	// together. Mutually exclusive with APIServer and APIServices.
+	Servers []serverConfig `json:"servers,omitempty"`
+
+	// CRDs are paths to CRD definition files or directories installed alongside the aggregated api servers.
+	// Their served group versions are extracted as well.
+	CRDs []string `json:"crds,omitempty"`
+	// Webhooks are paths to mutating and validating webhook configuration files or directories installed
+	// alongside the aggregated api servers.
+	Webhooks []string `json:"webhooks,omitempty"`
+
+	// ControlPlane configures the control planes to extract the OpenAPI specs against.
+	ControlPlane controlPlaneConfig `json:"controlPlane,omitempty"`
+
+	// Filters restrict the extracted group versions.
+	Filters filterConfig `json:"filters,omitempty"`
+
+	// Output configures the output layout.
+	Output outputConfig `json:"output,omitempty"`
+
+	// PostProcess are commands run after all outputs of the target were written.
+	PostProcess []postProcessStep `json:"postProcess,omitempty"`
+
+	// SkipValidation disables validating the extracted OpenAPI documents.
+	SkipValidation bool `json:"skipValidation,omitempty"`
+	// SkipDiscovery disables extracting the discovery documents.
+	SkipDiscovery bool `json:"skipDiscovery,omitempty"`
+}
+
+type apiServerConfig struct {
+	// Package is the Go package to build the api server from.
+	Package string `json:"package,omitempty"`
+	// Build configures how Package is built.
+	Build buildConfig `json:"build,omitempty"`
+	// Command is the command to run the api server. Mutually exclusive with Package.
+	Command []string `json:"command,omitempty"`
+	// Args are additional arguments passed to the api server, merged with the default arguments.
+	// An argument without values is passed as flag without value, e.g. --enable-feature.
+	Args map[string][]string `json:"args,omitempty"`
+	// DisabledArgs are default arguments not to pass to the api server, e.g. audit-log-path.
+	DisabledArgs []string `json:"disabledArgs,omitempty"`
+	// Env are additional environment variables of the api server process.
+	Env map[string]string `json:"env,omitempty"`
+	// Restart configures how often starting the api server is attempted.
+	Restart restartConfig `json:"restart,omitempty"`
+	// Probe configures how the api server is probed until it is healthy.
+	Probe probeConfig `json:"probe,omitempty"`
+}
+
+type probeConfig struct {
+	// Paths are HTTP paths that all have to respond with 200 OK. Defaults to /readyz unless Exec is set.
+	Paths []string `json:"paths,omitempty"`
+	// Exec is a command that has to exit successfully. It receives the URL of the api server via APISERVER_URL.
+	Exec []string `json:"exec,omitempty"`
+	// ClientCert authenticates the HTTP probes with the client certificate of the control plane.
+	ClientCert bool `json:"clientCert,omitempty"`
+}
+
+type restartConfig struct {
+	// MaxAttempts is the maximum number of attempts to start the api server until it becomes healthy.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// Backoff is the delay before the first restart. It doubles with every further restart.
+	Backoff *metav1.Duration `json:"backoff,omitempty"`
+}
+
+// restartPolicy returns the restart policy of the config, defaulted by the command line flags.
+func (c *restartConfig) restartPolicy() apiserver.RestartPolicy {
+	policy := apiserver.RestartPolicy{
+		MaxAttempts:    apiServerStartAttempts,
+		InitialBackoff: apiServerStartBackoff,
+	}
+	if c.MaxAttempts > 0 {
+		policy.MaxAttempts = c.MaxAttempts
+	}
+	if c.Backoff != nil {
+		policy.InitialBackoff = c.Backoff.Duration
+	}
+	return policy
+}
+
+type buildConfig struct {
+	// Tags are the build tags.
+	Tags []string `json:"tags,omitempty"`
+	// LDFlags are the linker flags.
+	LDFlags []string `json:"ldflags,omitempty"`
+	// VersionStamps are variables set at link time via -ldflags -X, keyed by their fully qualified name.
+	VersionStamps map[string]string `json:"versionStamps,omitempty"`
+	// TrimPath removes file system paths from the binary.
+	TrimPath bool `json:"trimpath,omitempty"`
+	// Mod is the module download mode (vendor, mod or readonly).
+	Mod string `json:"mod,omitempty"`
+	// GoFlags are additional flags passed via GOFLAGS.
+	GoFlags []string `json:"goflags,omitempty"`
+	// Env are additional environment variables for the build, e.g. CGO_ENABLED or GOWORK.
+	Env map[string]string `json:"env,omitempty"`
+	// Dir is a local module directory to build the package from, e.g. a checkout of the api server.
+	Dir string `json:"dir,omitempty"`
+	// Workspace are local module directories overlaid via an ephemeral go.work file.
+	Workspace []string `json:"workspace,omitempty"`
+}
+
+// buildOptions converts the build config into apiserver.BuildOptions.
+func (c *buildConfig) buildOptions() (apiserver.BuildOptions, error) {
+	opts := apiserver.BuildOptions{
+		Tags:          c.Tags,
+		LDFlags:       c.LDFlags,
+		VersionStamps: c.VersionStamps,
+		TrimPath:      c.TrimPath,
+		GoFlags:       c.GoFlags,
+		Workspace:     c.Workspace,
+	}
+	if c.Dir != "" {
+		dir, err := filepath.Abs(c.Dir)
+		if err != nil {
+			return apiserver.BuildOptions{}, fmt.Errorf("error determining absolute module directory: %w", err)
+		}
+		opts.Dir = dir
+	}
+	if c.Mod != "" {
+		mod, err := apiserver.ParseModMode(c.Mod)
+		if err != nil {
+			return apiserver.BuildOptions{}, err
+		}
+		opts.Mod = mod
+	}
+
+	opts.Env = envList(c.Env)
+	return opts, nil
+}
+
+// envList converts the given environment variables into KEY=VALUE pairs, sorted by key.
+func envList(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := make([]string, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, fmt.Sprintf("%s=%s", key, env[key]))
+	}
+	return res
+}
+
+// parseAPIServerArgs parses arguments of the form key=value. Repeating a key passes the argument
+// multiple times, a key without value is passed as flag without value and a key suffixed with '-'
+// removes the default argument of that name.
+func parseAPIServerArgs(values []string) (args map[string][]string, disabled []string, err error) {
+	args = make(map[string][]string)
+	for _, value := range values {
+		key, val, hasValue := strings.Cut(strings.TrimLeft(value, "-"), "=")
+		if key == "" {
+			return nil, nil, fmt.Errorf("invalid api server argument %q", value)
+		}
+		if !hasValue && strings.HasSuffix(key, "-") {
+			disabled = append(disabled, strings.TrimSuffix(key, "-"))
+			continue
+		}
+		if !hasValue {
+			args[key] = []string{}
+			continue
+		}
+		args[key] = append(args[key], val)
+	}
+	return args, disabled, nil
+}
+
+// parseEnv parses environment variables of the form KEY=VALUE.
+func parseEnv(values []string) (map[string]string, error) {
+	env := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid environment variable %q, must be KEY=VALUE", value)
+		}
+		env[key] = val
+	}
+	return env, nil
+}
+
+type controlPlaneConfig struct {
+	// K8sVersions are Kubernetes versions or semver constraints of the control planes.
+	K8sVersions []string `json:"k8sVersions,omitempty"`
+	// BinaryAssetsDirs are directories containing envtest binaries.
+	BinaryAssetsDirs []string `json:"binaryAssetsDirs,omitempty"`
+}
+
+type filterConfig struct {
+	// GroupVersions are the group versions to extract. If empty, all group versions of the APIServices and CRDs
+	// are extracted.
+	GroupVersions []string `json:"groupVersions,omitempty"`
+	// ExcludeGroupVersions are group versions not to extract.
+	ExcludeGroupVersions []string `json:"excludeGroupVersions,omitempty"`
+}
+
+type outputConfig struct {
+	// Dir is the directory to write the outputs to.
+	Dir string `json:"dir,omitempty"`
+	// V2File is the name of the OpenAPI v2 file within Dir.
+	V2File string `json:"v2File,omitempty"`
+	// V3Dir is the directory of the OpenAPI v3 files within Dir.
+	V3Dir string `json:"v3Dir,omitempty"`
+	// DiscoveryDir is the directory of the discovery files within Dir.
+	DiscoveryDir string `json:"discoveryDir,omitempty"`
+}
+
+type postProcessStep struct {
+	// Command is the command to run. It is run within the output directory of the target, which is
+	// additionally passed via the OPENAPI_EXTRACTOR_OUTPUT_DIR environment variable.
+	Command []string `json:"command"`
+}
+
+func setOutputConfigDefaults(o *outputConfig) {
+	if o.Dir == "" {
+		o.Dir = "."
+	}
+	if o.V2File == "" {
+		o.V2File = "swagger.json"
+	}
+	if o.V3Dir == "" {
+		o.V3Dir = "v3"
+	}
+	if o.DiscoveryDir == "" {
+		o.DiscoveryDir = discoveryDir
+	}
+}
+
+// loadConfig reads the config file at path. Relative paths within the file are resolved
+// relative to the directory of the file.
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	baseDir := filepath.Dir(path)
+	names := sets.New[string]()
+	for i := range cfg.Targets {
+		t := &cfg.Targets[i]
+		if t.Name == "" {
+			return nil, fmt.Errorf("target %d: must specify name", i)
+		}
+		if names.Has(t.Name) {
+			return nil, fmt.Errorf("duplicate target %s", t.Name)
+		}
+		names.Insert(t.Name)
+
+		if err := validateServers(t); err != nil {
+			return nil, fmt.Errorf("target %s: %w", t.Name, err)
+		}
+
+		t.APIServices = resolvePaths(baseDir, t.APIServices)
+		t.CRDs = resolvePaths(baseDir, t.CRDs)
+		t.Webhooks = resolvePaths(baseDir, t.Webhooks)
+		t.ControlPlane.BinaryAssetsDirs = resolvePaths(baseDir, t.ControlPlane.BinaryAssetsDirs)
+		resolveAPIServerPaths(baseDir, &t.APIServer)
+		for j := range t.Servers {
+			srv := &t.Servers[j]
+			srv.APIServices = resolvePaths(baseDir, srv.APIServices)
+			resolveAPIServerPaths(baseDir, &srv.APIServer)
+		}
+		if t.Output.Dir != "" {
+			t.Output.Dir = resolvePath(baseDir, t.Output.Dir)
+		} else {
+			t.Output.Dir = baseDir
+		}
+	}
+	return cfg, nil
+}
+
+// validateServers validates the servers of the target.
+func validateServers(t *target) error {
+	if len(t.Servers) == 0 {
+		return nil
+	}
+	if t.APIServer.Package != "" || len(t.APIServer.Command) > 0 || len(t.APIServices) > 0 {
+		return fmt.Errorf("must not specify apiServer or apiServices together with servers")
+	}
+
+	names := sets.New[string]()
+	for i, srv := range t.Servers {
+		if errs := validation.IsDNS1123Label(srv.Name); len(errs) > 0 {
+			return fmt.Errorf("server %d: invalid name %q: %v", i, srv.Name, errs)
+		}
+		if names.Has(srv.Name) {
+			return fmt.Errorf("duplicate server %s", srv.Name)
+		}
+		names.Insert(srv.Name)
+	}
+	return nil
+}
+
+func resolveAPIServerPaths(baseDir string, c *apiServerConfig) {
+	c.Build.Workspace = resolvePaths(baseDir, c.Build.Workspace)
+	if c.Build.Dir != "" {
+		c.Build.Dir = resolvePath(baseDir, c.Build.Dir)
+	}
+}
+
+func resolvePath(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+func resolvePaths(baseDir string, paths []string) []string {
+	res := make([]string, 0, len(paths))
+	for _, path := range paths {
+		res = append(res, resolvePath(baseDir, path))
+	}
+	return res
+}
+
+// selectTargets returns the targets with the given names in order of the config.
+// If no names are given, all targets are returned.
+func selectTargets(cfg *config, names []string) ([]target, error) {
+	if len(names) == 0 {
+		return cfg.Targets, nil
+	}
+
+	wanted := sets.New(names...)
+	var res []target
+	for _, t := range cfg.Targets {
+		if wanted.Has(t.Name) {
+			res = append(res, t)
+			wanted.Delete(t.Name)
+		}
+	}
+	if wanted.Len() > 0 {
+		return nil, fmt.Errorf("unknown targets %v", sets.List(wanted))
+	}
+	return res, nil
+}
+
+// loadTargets determines the targets to extract. Without a config file, a single target is created
+// from the command line flags. Otherwise, the flags explicitly set on the command line override the
+// settings of each selected target.
+func loadTargets(fs *flag.FlagSet) ([]target, error) {
+	path := configFile
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			path = defaultConfigFile
+		}
+	}
+
+	if path == "" {
+		if len(targetNames) > 0 {
+			return nil, fmt.Errorf("--target requires a config file")
+		}
+		t := target{Name: defaultTargetName}
+		if err := applyFlagOverrides(fs, &t, false); err != nil {
+			return nil, err
+		}
+		setOutputConfigDefaults(&t.Output)
+		return []target{t}, nil
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("config file %s not found", path)
+		}
+		return nil, err
+	}
+
+	targets, err := selectTargets(cfg, targetNames)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("config file %s does not declare any targets", path)
+	}
+
+	for i := range targets {
+		if err := applyFlagOverrides(fs, &targets[i], len(targets) > 1); err != nil {
+			return nil, err
+		}
+		setOutputConfigDefaults(&targets[i].Output)
+	}
+	return targets, nil
+}
+
+// applyFlagOverrides overrides the settings of the target with all flags set on the command line.
+// If the output directory is overridden for multiple targets, each target gets its own subdirectory.
+// For targets with multiple servers, the build flags apply to all servers.
+func applyFlagOverrides(fs *flag.FlagSet, t *target, multipleTargets bool) error {
+	changed := func(name string) bool {
+		return fs.Changed(name)
+	}
+
+	if len(t.Servers) > 0 {
+		for _, name := range []string{"apiserver-package", "apiserver-command", "apiservices"} {
+			if changed(name) {
+				return fmt.Errorf("target %s: --%s cannot be used for targets with servers", t.Name, name)
+			}
+		}
+		for i := range t.Servers {
+			applyBuildFlagOverrides(changed, &t.Servers[i].APIServer.Build)
+			if err := applyProcessFlagOverrides(changed, &t.Servers[i].APIServer); err != nil {
+				return fmt.Errorf("target %s: %w", t.Name, err)
+			}
+		}
+	}
+
+	if changed("apiserver-package") {
+		t.APIServer.Package = apiServerPackage
+		t.APIServer.Command = nil
+	}
+	if changed("apiserver-command") {
+		t.APIServer.Command = apiServerCommand
+		t.APIServer.Package = ""
+	}
+	applyBuildFlagOverrides(changed, &t.APIServer.Build)
+	if err := applyProcessFlagOverrides(changed, &t.APIServer); err != nil {
+		return fmt.Errorf("target %s: %w", t.Name, err)
+	}
+	if changed("apiservices") {
+		t.APIServices = apiServicePaths
+	}
+	if changed("crds") {
+		t.CRDs = crdPaths
+	}
+	if changed("webhooks") {
+		t.Webhooks = webhookPaths
+	}
+	if changed("k8s-version") || changed("binary-assets-dir") {
+		t.ControlPlane.K8sVersions = k8sVersions
+		t.ControlPlane.BinaryAssetsDirs = binaryAssetsDirs
+	}
+	if changed("output") {
+		t.Output.Dir = outputDir
+		if multipleTargets {
+			t.Output.Dir = filepath.Join(outputDir, t.Name)
+		}
+	}
+	if changed("skip-validation") {
+		t.SkipValidation = skipValidation
+	}
+	if changed("skip-discovery") {
+		t.SkipDiscovery = skipDiscovery
+	}
+	return nil
+}
+
+// applyProcessFlagOverrides merges the api server arguments, environment variables, restart and probe
+// settings set on the command line into the config. Flags take precedence over config entries with the same key.
+func applyProcessFlagOverrides(changed func(name string) bool, c *apiServerConfig) error {
+	if changed("apiserver-arg") {
+		args, disabled, err := parseAPIServerArgs(apiServerArgs)
+		if err != nil {
+			return err
+		}
+
+		merged := make(map[string][]string, len(c.Args)+len(args))
+		for key, values := range c.Args {
+			merged[key] = values
+		}
+		for _, key := range disabled {
+			delete(merged, key)
+		}
+		for key, values := range args {
+			merged[key] = values
+		}
+		c.Args = merged
+
+		disabledSet := sets.New(c.DisabledArgs...).Insert(disabled...)
+		for key := range args {
+			disabledSet.Delete(key)
+		}
+		c.DisabledArgs = sets.List(disabledSet)
+	}
+	if changed("apiserver-start-attempts") {
+		c.Restart.MaxAttempts = apiServerStartAttempts
+	}
+	if changed("apiserver-start-backoff") {
+		c.Restart.Backoff = &metav1.Duration{Duration: apiServerStartBackoff}
+	}
+	if changed("apiserver-probe-path") {
+		c.Probe.Paths = apiServerProbe.Paths
+	}
+	if changed("apiserver-probe-exec") {
+		c.Probe.Exec = apiServerProbe.Exec
+	}
+	if changed("apiserver-probe-client-cert") {
+		c.Probe.ClientCert = apiServerProbe.ClientCert
+	}
+	if changed("apiserver-env") {
+		env, err := parseEnv(apiServerEnv)
+		if err != nil {
+			return err
+		}
+
+		merged := make(map[string]string, len(c.Env)+len(env))
+		for key, value := range c.Env {
+			merged[key] = value
+		}
+		for key, value := range env {
+			merged[key] = value
+		}
+		c.Env = merged
+	}
+	return nil
+}
+
+func applyBuildFlagOverrides(changed func(name string) bool, c *buildConfig) {
+	if changed("apiserver-build-opts") && len(apiServerBuildOpts) > 0 {
+		c.Mod = apiServerBuildOpts[len(apiServerBuildOpts)-1]
+	}
+	if changed("apiserver-build-tags") {
+		c.Tags = apiServerBuild.Tags
+	}
+	if changed("apiserver-build-ldflags") {
+		c.LDFlags = apiServerBuild.LDFlags
+	}
+	if changed("apiserver-build-version-stamp") {
+		c.VersionStamps = apiServerBuild.VersionStamps
+	}
+	if changed("apiserver-build-trimpath") {
+		c.TrimPath = apiServerBuild.TrimPath
+	}
+	if changed("apiserver-build-mod") {
+		c.Mod = apiServerBuild.Mod
+	}
+	if changed("apiserver-build-goflags") {
+		c.GoFlags = apiServerBuild.GoFlags
+	}
+	if changed("apiserver-build-env") {
+		c.Env = apiServerBuild.Env
+	}
+	if changed("apiserver-module-dir") {
+		c.Dir = apiServerBuild.Dir
+	}
+	if changed("apiserver-workspace-module") {
+		c.Workspace = apiServerBuild.Workspace
+	}
+}
+
+// groupVersions returns the group versions of the given APIServices and CRDs included by the filters.
+func (f *filterConfig) groupVersions(services []*apiregistrationv1.APIService, crds ...*apiextensionsv1.CustomResourceDefinition) []schema.GroupVersion {
+	gvs := sets.New(apiServiceGroupVersions(services)...).Insert(crdGroupVersions(crds)...)
+	var res []schema.GroupVersion
+	for _, gv := range sortedGroupVersions(gvs.UnsortedList()) {
+		if f.includesGroupVersion(gv) {
+			res = append(res, gv)
+		}
+	}
+	return res
+}
+
+// includesGroupVersion reports whether the filters of the target include the given group version.
+func (f *filterConfig) includesGroupVersion(gv schema.GroupVersion) bool {
+	if len(f.GroupVersions) > 0 && !sets.New(f.GroupVersions...).Has(gv.String()) {
+		return false
+	}
+	return !sets.New(f.ExcludeGroupVersions...).Has(gv.String())
+}
@@ -0,0 +1,565 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/ironcore-dev/openapi-extractor/envtestutils"
+	flag "github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+const (
+	daemonSocketFileName = "openapi-extractor.sock"
+	daemonExtractPath    = "/extract"
+
+	// daemonControlPlaneName is the name of the control plane of extractions with --daemon-socket until
+	// the daemon reports the actual one.
+	daemonControlPlaneName = "daemon"
+
+	// daemonNamespacePrefix prefixes the namespace of the services of a target.
+	daemonNamespacePrefix = "openapi-extractor-"
+	// daemonEtcdPrefix prefixes the etcd prefix of the aggregated api servers of a target.
+	daemonEtcdPrefix = "/openapi-extractor/"
+
+	daemonShutdownTimeout = 10 * time.Second
+)
+
+func defaultDaemonSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, daemonSocketFileName)
+}
+
+// runDaemonCommand implements the `daemon` command running a single control plane that extracts the
+// targets sent by other invocations via --daemon-socket.
+func runDaemonCommand(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	socket := defaultDaemonSocket()
+	var k8sVersion, binaryAssetsDir string
+	fs.StringVar(&socket, "socket", socket, "Unix socket to listen on for extraction requests")
+	fs.StringVar(&k8sVersion, "k8s-version", k8sVersion, "Kubernetes control plane version or semver constraint to run. The newest matching locally installed version is used.")
+	fs.StringVar(&binaryAssetsDir, "binary-assets-dir", binaryAssetsDir, "Directory containing the envtest control plane binaries to run")
+	fs.StringVar(&envtestBinDir, "envtest-bin-dir", envtestBinDir, "Directory setup-envtest installed the envtest binaries into, used to look up --k8s-version")
+	fs.StringVar(&runDir, "run-dir", runDir, "Directory to store the logs of the extractions in (default: a new temporary directory)")
+	fs.BoolVar(&attachControlPlaneOutput, "attach-control-plane-output", attachControlPlaneOutput, "Whether to print control plane output to stdout/stderr")
+	fs.BoolVar(&attachAPIServerOutput, "attach-apiserver-output", attachAPIServerOutput, "Whether to print api server output to stdout/stderr")
+	fs.BoolVar(&useBuildCache, "apiserver-build-cache", useBuildCache, "Whether to reuse previously built api server binaries if their sources did not change")
+	fs.StringVar(&buildCacheDir, "apiserver-build-cache-dir", buildCacheDir, "Directory of the api server build cache")
+	fs.IntVar(&logTailLines, "log-tail-lines", logTailLines, "Number of last log lines of each component to return if an extraction fails")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: openapi-extractor daemon [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if k8sVersion != "" && binaryAssetsDir != "" {
+		return fmt.Errorf("must not specify --k8s-version and --binary-assets-dir simultaneously")
+	}
+
+	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
+
+	resolver := &binaryAssetsResolver{
+		KubebuilderAssets: os.Getenv(envKubebuilderAssets),
+		BinDir:            envtestBinDir,
+	}
+	var versions, dirs []string
+	if k8sVersion != "" {
+		versions = append(versions, k8sVersion)
+	}
+	if binaryAssetsDir != "" {
+		dirs = append(dirs, binaryAssetsDir)
+	}
+	planes, err := controlPlanes(resolver, versions, dirs)
+	if err != nil {
+		return fmt.Errorf("failed to determine control plane: %w", err)
+	}
+
+	generatedRunDir, err := setupRunDir()
+	if err != nil {
+		return err
+	}
+	log.Info("Using run directory", "RunDirectory", runDir)
+	pruneBuildCache()
+
+	d := &daemon{
+		log:   log.WithValues("ControlPlane", planes[0].Name),
+		plane: planes[0],
+	}
+	if err := d.start(); err != nil {
+		return err
+	}
+	serveErr := d.serve(ctrl.SetupSignalHandler(), socket)
+	if err := d.stop(); err != nil {
+		serveErr = errors.Join(serveErr, err)
+	}
+	cleanupRunDir(generatedRunDir, serveErr)
+	return serveErr
+}
+
+// daemon runs a single control plane and extracts the targets sent to it. The aggregated api servers of a
+// target keep running until the target is extracted again or its APIServices are needed by another target.
+type daemon struct {
+	log   logr.Logger
+	plane controlPlane
+
+	env       *envtest.Environment
+	ext       *envtestutils.EnvironmentExtensions
+	cfg       *rest.Config
+	client    client.Client
+	clientSet *kubernetes.Clientset
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// targets are the targets being extracted or with installed aggregated api servers by name.
+	targets map[string]*daemonTarget
+	// owners are the targets by the names of the APIServices they serve.
+	owners map[string]string
+}
+
+// daemonTarget is a target registered in the control plane of the daemon.
+type daemonTarget struct {
+	name string
+	// busy is set while the target is being extracted.
+	busy        bool
+	apiServices sets.Set[string]
+	gvs         []schema.GroupVersion
+	installed   *daemonInstallation
+}
+
+// daemonInstallation are the installed and running aggregated api servers of a target.
+type daemonInstallation struct {
+	target         string
+	ext            *envtestutils.EnvironmentExtensions
+	stopAPIServers func() error
+}
+
+func (d *daemon) start() error {
+	d.cond = sync.NewCond(&d.mu)
+	d.targets = make(map[string]*daemonTarget)
+	d.owners = make(map[string]string)
+
+	d.env = &envtest.Environment{
+		AttachControlPlaneOutput: attachControlPlaneOutput,
+		BinaryAssetsDirectory:    d.plane.BinaryAssetsDirectory,
+	}
+	d.ext = &envtestutils.EnvironmentExtensions{
+		LogDir:     filepath.Join(runDir, d.plane.Name, "logs"),
+		LogOptions: logOptions(),
+	}
+	d.log.Info("Starting control plane")
+	cfg, err := envtestutils.StartWithExtensions(d.env, d.ext)
+	if err != nil {
+		return fmt.Errorf("failed to start testenv: %w", err)
+	}
+	d.cfg = cfg
+
+	if d.client, err = client.New(cfg, client.Options{Scheme: scheme.Scheme}); err != nil {
+		_ = d.stop()
+		return fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	if d.clientSet, err = kubernetes.NewForConfig(cfg); err != nil {
+		_ = d.stop()
+		return fmt.Errorf("failed to create clientset from config: %w", err)
+	}
+	return nil
+}
+
+// stop tears down all targets and stops the control plane.
+func (d *daemon) stop() error {
+	d.mu.Lock()
+	var installed []*daemonInstallation
+	for _, dt := range d.targets {
+		if dt.installed != nil {
+			installed = append(installed, dt.installed)
+		}
+	}
+	d.targets = make(map[string]*daemonTarget)
+	d.owners = make(map[string]string)
+	d.mu.Unlock()
+
+	var errs []error
+	for _, inst := range installed {
+		if err := d.teardown(inst); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	d.log.Info("Stopping control plane")
+	if err := envtestutils.StopWithExtensions(d.env, d.ext); err != nil {
+		errs = append(errs, fmt.Errorf("failed to stop testenv: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// serve serves extraction requests on the Unix socket until ctx is done.
+func (d *daemon) serve(ctx context.Context, socket string) error {
+	if err := removeStaleSocket(socket); err != nil {
+		return err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+	defer func() { _ = os.Remove(socket) }()
+	if err := os.Chmod(socket, 0600); err != nil {
+		_ = listener.Close()
+		return fmt.Errorf("failed to restrict access to %s: %w", socket, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+daemonExtractPath, d.handleExtract)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		// Running extractions are cancelled once the daemon is stopped.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), daemonShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			_ = srv.Close()
+		}
+	}()
+
+	d.log.Info("Listening for extraction requests", "Socket", socket)
+	if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve extraction requests: %w", err)
+	}
+	return nil
+}
+
+// removeStaleSocket removes the socket left behind by a daemon that was not stopped cleanly.
+func removeStaleSocket(socket string) error {
+	if _, err := os.Stat(socket); err != nil {
+		return nil
+	}
+	if conn, err := net.DialTimeout("unix", socket, 1*time.Second); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("another daemon is listening on %s", socket)
+	}
+	if err := os.Remove(socket); err != nil {
+		return fmt.Errorf("failed to remove stale socket %s: %w", socket, err)
+	}
+	return nil
+}
+
+func (d *daemon) handleExtract(w http.ResponseWriter, r *http.Request) {
+	req := &daemonRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeDaemonResponse(w, http.StatusBadRequest, &daemonResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	t := &req.Target
+	key := daemonTargetKey(req.Dir, t.Name)
+	targetLog := d.log.WithValues("Target", t.Name, "Key", key)
+	// The logs are stored per key, as several clients may extract targets with the same name.
+	rec := &extractionRecord{Target: key, ControlPlane: d.plane.Name}
+	res, err := d.extract(r.Context(), targetLog, key, t, rec)
+	if err != nil {
+		targetLog.Error(err, "Extraction failed")
+		rec.Error = err.Error()
+		var logs bytes.Buffer
+		rec.dumpLogs(&logs, logTailLines)
+		writeDaemonResponse(w, http.StatusOK, &daemonResponse{Error: err.Error(), Record: rec, Logs: logs.String()})
+		return
+	}
+	targetLog.Info("Extraction succeeded")
+	rec.Succeeded = true
+	writeDaemonResponse(w, http.StatusOK, &daemonResponse{Record: rec, Result: newDaemonResult(res)})
+}
+
+func writeDaemonResponse(w http.ResponseWriter, status int, resp *daemonResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// daemonTargetKey identifies the target of a client within the daemon. All clients driven by flags use the
+// default target name, so the key is derived from the working directory of the client as well.
+func daemonTargetKey(dir, name string) string {
+	sum := sha256.Sum256([]byte(dir + "\x00" + name))
+	return fmt.Sprintf("%s-%x", name, sum[:4])
+}
+
+// extract starts or replaces the aggregated api servers of the target identified by key and extracts them.
+func (d *daemon) extract(ctx context.Context, log logr.Logger, key string, t *target, rec *extractionRecord) (*extractionResult, error) {
+	if len(t.CRDs) > 0 || len(t.Webhooks) > 0 {
+		// CRDs and webhook configurations are cluster-scoped and cannot be isolated between targets.
+		return nil, fmt.Errorf("crds and webhooks are not supported by the daemon")
+	}
+
+	namespace := daemonNamespacePrefix + key
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return nil, fmt.Errorf("target name %q is invalid for the namespace %s: %v", t.Name, namespace, errs)
+	}
+
+	servers := t.servers()
+	apiServices, gvs, err := readTargetAPIServices(servers)
+	if err != nil {
+		return nil, err
+	}
+
+	dt, evicted, err := d.acquire(ctx, log, key, apiServices, gvs)
+	if err != nil {
+		return nil, err
+	}
+	var installed *daemonInstallation
+	defer func() { d.release(dt, installed) }()
+
+	for _, inst := range evicted {
+		if err := d.teardown(inst); err != nil {
+			return nil, fmt.Errorf("failed to tear down target %s: %w", inst.target, err)
+		}
+	}
+
+	inst, err := d.install(ctx, log, key, namespace, servers, rec)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := extract(ctx, log, t, servers, inst.ext, d.client, d.clientSet, rec)
+	if err == nil {
+		// The OpenAPI v2 spec of the control plane contains the paths of all targets.
+		res.v2, err = excludeOpenAPIv2(res.v2, d.foreignGroupVersions(key))
+	}
+	if err != nil {
+		if err := d.teardown(inst); err != nil {
+			log.Error(err, "failed to tear down target")
+		}
+		return nil, err
+	}
+	installed = inst
+	return res, nil
+}
+
+// readTargetAPIServices returns the names of the APIServices of all servers and the group versions served by them.
+func readTargetAPIServices(servers []serverConfig) (sets.Set[string], []schema.GroupVersion, error) {
+	names := sets.New[string]()
+	var gvs []schema.GroupVersion
+	for i := range servers {
+		apiServices, err := envtestutils.ReadAPIServices(servers[i].APIServices, true)
+		if err != nil {
+			return nil, nil, fmt.Errorf("server %s: failed to read APIServices: %w", servers[i].Name, err)
+		}
+		for _, apiService := range apiServices {
+			names.Insert(apiService.Name)
+		}
+		gvs = append(gvs, apiServiceGroupVersions(apiServices)...)
+	}
+	return names, gvs, nil
+}
+
+// acquire registers the target as busy once neither it nor any other target serving one of its APIServices is
+// being extracted. The previous installation of the target and the ones of other targets serving its APIServices
+// are returned to be torn down.
+func (d *daemon) acquire(
+	ctx context.Context,
+	log logr.Logger,
+	name string,
+	apiServices sets.Set[string],
+	gvs []schema.GroupVersion,
+) (*daemonTarget, []*daemonInstallation, error) {
+	stopWaking := context.AfterFunc(ctx, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.cond.Broadcast()
+	})
+	defer stopWaking()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for d.blocked(name, apiServices) {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		log.V(1).Info("Waiting for conflicting extractions to finish")
+		d.cond.Wait()
+	}
+
+	conflicting := sets.New(name)
+	for apiService := range apiServices {
+		if owner, ok := d.owners[apiService]; ok {
+			conflicting.Insert(owner)
+		}
+	}
+	var evicted []*daemonInstallation
+	for _, other := range sets.List(conflicting) {
+		dt, ok := d.targets[other]
+		if !ok {
+			continue
+		}
+		if other != name {
+			log.Info("Evicting target serving the same APIServices", "EvictedTarget", other)
+		}
+		if dt.installed != nil {
+			evicted = append(evicted, dt.installed)
+		}
+		d.unregister(dt)
+	}
+
+	dt := &daemonTarget{
+		name:        name,
+		busy:        true,
+		apiServices: apiServices,
+		gvs:         gvs,
+	}
+	d.targets[name] = dt
+	for apiService := range apiServices {
+		d.owners[apiService] = name
+	}
+	return dt, evicted, nil
+}
+
+// blocked reports whether the target or any target serving one of the APIServices is being extracted.
+func (d *daemon) blocked(name string, apiServices sets.Set[string]) bool {
+	if dt, ok := d.targets[name]; ok && dt.busy {
+		return true
+	}
+	for apiService := range apiServices {
+		if owner, ok := d.owners[apiService]; ok && d.targets[owner].busy {
+			return true
+		}
+	}
+	return false
+}
+
+// release marks the target as idle. Without installation, the target is unregistered.
+func (d *daemon) release(dt *daemonTarget, installed *daemonInstallation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dt.busy = false
+	dt.installed = installed
+	if installed == nil && d.targets[dt.name] == dt {
+		d.unregister(dt)
+	}
+	d.cond.Broadcast()
+}
+
+func (d *daemon) unregister(dt *daemonTarget) {
+	for apiService := range dt.apiServices {
+		if d.owners[apiService] == dt.name {
+			delete(d.owners, apiService)
+		}
+	}
+	delete(d.targets, dt.name)
+}
+
+// foreignGroupVersions returns the group versions served by all other targets.
+func (d *daemon) foreignGroupVersions(name string) []schema.GroupVersion {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var gvs []schema.GroupVersion
+	for _, dt := range d.targets {
+		if dt.name != name {
+			gvs = append(gvs, dt.gvs...)
+		}
+	}
+	return gvs
+}
+
+// install installs the APIServices of the target into its own namespace and starts its aggregated api servers.
+// The api servers of the target store their resources under their own etcd prefix.
+func (d *daemon) install(
+	ctx context.Context,
+	log logr.Logger,
+	key string,
+	namespace string,
+	servers []serverConfig,
+	rec *extractionRecord,
+) (*daemonInstallation, error) {
+	if err := d.client.Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: namespace},
+	}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create namespace %s: %w", namespace, err)
+	}
+
+	ext := environmentExtensions(servers)
+	for _, opts := range ext.AllAPIServiceInstallOptions() {
+		opts.ServiceNamespace = namespace
+	}
+	if err := envtestutils.InstallWithExtensions(d.cfg, d.ext, ext); err != nil {
+		return nil, fmt.Errorf("failed to install APIServices: %w", err)
+	}
+	inst := &daemonInstallation{target: key, ext: ext}
+
+	servers = withEtcdPrefix(servers, daemonEtcdPrefix+key)
+	_, stopAPIServers, err := startAPIServers(ctx, log, d.cfg, d.env, ext, servers, rec)
+	if err != nil {
+		if err := d.teardown(inst); err != nil {
+			log.Error(err, "failed to tear down target")
+		}
+		return nil, err
+	}
+	inst.stopAPIServers = stopAPIServers
+	return inst, nil
+}
+
+// withEtcdPrefix returns the servers with the given etcd prefix unless they configure their own.
+func withEtcdPrefix(servers []serverConfig, prefix string) []serverConfig {
+	res := make([]serverConfig, len(servers))
+	for i := range servers {
+		res[i] = servers[i]
+		if _, ok := servers[i].APIServer.Args["etcd-prefix"]; ok {
+			continue
+		}
+		args := maps.Clone(servers[i].APIServer.Args)
+		if args == nil {
+			args = make(map[string][]string)
+		}
+		args["etcd-prefix"] = []string{prefix}
+		res[i].APIServer.Args = args
+	}
+	return res
+}
+
+// teardown stops the aggregated api servers of a target and uninstalls its APIServices. The api servers are
+// stopped first, as uninstalling removes their serving certificates and releases their ports.
+func (d *daemon) teardown(inst *daemonInstallation) error {
+	var errs []error
+	if inst.stopAPIServers != nil {
+		if err := inst.stopAPIServers(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := envtestutils.UninstallWithExtensions(d.cfg, inst.ext); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
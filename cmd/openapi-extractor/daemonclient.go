@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-logr/logr"
+	apidiscoveryv2 "k8s.io/api/apidiscovery/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// daemonRequest asks the daemon to start or replace the aggregated api servers of the target and to extract them.
+type daemonRequest struct {
+	// Target is the target to extract. Its paths are absolute.
+	Target target `json:"target"`
+	// Dir is the working directory of the client.
+	Dir string `json:"dir"`
+}
+
+// daemonResponse is the outcome of an extraction by the daemon.
+type daemonResponse struct {
+	Error  string            `json:"error,omitempty"`
+	Record *extractionRecord `json:"record,omitempty"`
+	// Logs are the last lines of the logs of a failed extraction.
+	Logs   string        `json:"logs,omitempty"`
+	Result *daemonResult `json:"result,omitempty"`
+}
+
+// daemonResult is the serialized form of an extractionResult.
+type daemonResult struct {
+	V2        []byte               `json:"v2"`
+	V3        map[string][]byte    `json:"v3"`
+	Discovery *daemonDiscovery     `json:"discovery,omitempty"`
+	Servers   []daemonServerResult `json:"servers,omitempty"`
+}
+
+type daemonDiscovery struct {
+	Groups     *metav1.APIGroupList                  `json:"groups,omitempty"`
+	Resources  map[string]*metav1.APIResourceList    `json:"resources,omitempty"`
+	Aggregated *apidiscoveryv2.APIGroupDiscoveryList `json:"aggregated,omitempty"`
+}
+
+type daemonServerResult struct {
+	Name          string   `json:"name"`
+	V2            []byte   `json:"v2"`
+	GroupVersions []string `json:"groupVersions"`
+}
+
+func newDaemonResult(r *extractionResult) *daemonResult {
+	res := &daemonResult{
+		V2: r.v2,
+		V3: make(map[string][]byte, len(r.v3)),
+	}
+	for gv, data := range r.v3 {
+		res.V3[gv.String()] = data
+	}
+	if r.discovery != nil {
+		res.Discovery = &daemonDiscovery{
+			Groups:     r.discovery.Groups,
+			Resources:  make(map[string]*metav1.APIResourceList, len(r.discovery.Resources)),
+			Aggregated: r.discovery.Aggregated,
+		}
+		for gv, resources := range r.discovery.Resources {
+			res.Discovery.Resources[gv.String()] = resources
+		}
+	}
+	for _, srv := range r.servers {
+		gvs := make([]string, 0, len(srv.gvs))
+		for _, gv := range srv.gvs {
+			gvs = append(gvs, gv.String())
+		}
+		res.Servers = append(res.Servers, daemonServerResult{Name: srv.name, V2: srv.v2, GroupVersions: gvs})
+	}
+	return res
+}
+
+func (r *daemonResult) extractionResult() (*extractionResult, error) {
+	res := &extractionResult{
+		v2: r.V2,
+		v3: make(map[schema.GroupVersion][]byte, len(r.V3)),
+	}
+	for gvString, data := range r.V3 {
+		gv, err := schema.ParseGroupVersion(gvString)
+		if err != nil {
+			return nil, err
+		}
+		res.v3[gv] = data
+	}
+	if r.Discovery != nil {
+		res.discovery = &discoveryDocuments{
+			Groups:     r.Discovery.Groups,
+			Resources:  make(map[schema.GroupVersion]*metav1.APIResourceList, len(r.Discovery.Resources)),
+			Aggregated: r.Discovery.Aggregated,
+		}
+		for gvString, resources := range r.Discovery.Resources {
+			gv, err := schema.ParseGroupVersion(gvString)
+			if err != nil {
+				return nil, err
+			}
+			res.discovery.Resources[gv] = resources
+		}
+	}
+	for _, srv := range r.Servers {
+		gvs := make([]schema.GroupVersion, 0, len(srv.GroupVersions))
+		for _, gvString := range srv.GroupVersions {
+			gv, err := schema.ParseGroupVersion(gvString)
+			if err != nil {
+				return nil, err
+			}
+			gvs = append(gvs, gv)
+		}
+		res.servers = append(res.servers, serverResult{name: srv.Name, v2: srv.V2, gvs: gvs})
+	}
+	return res, nil
+}
+
+// extractWithDaemon sends the target to the daemon listening on --daemon-socket and returns its extraction.
+func extractWithDaemon(ctx context.Context, log logr.Logger, t *target, rec *extractionRecord) (*extractionResult, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine working directory: %w", err)
+	}
+	body, err := json.Marshal(&daemonRequest{Target: *absoluteTarget(wd, t), Dir: wd})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", daemonSocket)
+			},
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://openapi-extractor"+daemonExtractPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	log.Info("Extracting via daemon", "Socket", daemonSocket)
+	httpResp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to daemon: %w", err)
+	}
+	defer func() { _ = httpResp.Body.Close() }()
+
+	resp := &daemonResponse{}
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response of daemon (status %s): %w", httpResp.Status, err)
+	}
+	if resp.Record != nil {
+		rec.ControlPlane = resp.Record.ControlPlane
+		rec.LogFiles = resp.Record.LogFiles
+		rec.Diagnostics = resp.Record.Diagnostics
+	}
+	if resp.Logs != "" {
+		_, _ = fmt.Fprint(os.Stderr, resp.Logs)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	if resp.Result == nil {
+		return nil, fmt.Errorf("daemon returned no result (status %s)", httpResp.Status)
+	}
+	return resp.Result.extractionResult()
+}
+
+// absoluteTarget returns a copy of the target with all paths resolved against the working directory,
+// as the daemon runs in a different one. Packages are built in the working directory unless the target
+// specifies a module directory.
+func absoluteTarget(wd string, t *target) *target {
+	res := *t
+	res.APIServer = absoluteAPIServer(wd, t.APIServer)
+	res.APIServices = resolvePaths(wd, t.APIServices)
+	res.Servers = make([]serverConfig, 0, len(t.Servers))
+	for _, srv := range t.Servers {
+		srv.APIServer = absoluteAPIServer(wd, srv.APIServer)
+		srv.APIServices = resolvePaths(wd, srv.APIServices)
+		res.Servers = append(res.Servers, srv)
+	}
+	return &res
+}
+
+func absoluteAPIServer(wd string, c apiServerConfig) apiServerConfig {
+	resolveAPIServerPaths(wd, &c)
+	if c.Package != "" && c.Build.Dir == "" {
+		c.Build.Dir = wd
+	}
+	c.Command = absoluteCommand(wd, c.Command)
+	c.Probe.Exec = absoluteCommand(wd, c.Probe.Exec)
+	return c
+}
+
+// absoluteCommand resolves a relative executable path like ./bin/apiserver. Executables looked up in PATH are kept.
+func absoluteCommand(wd string, command []string) []string {
+	if len(command) == 0 || !strings.ContainsRune(command[0], filepath.Separator) {
+		return command
+	}
+	res := append([]string(nil), command...)
+	res[0] = resolvePath(wd, res[0])
+	return res
+}
@@ -0,0 +1,296 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/ironcore-dev/openapi-extractor/envtestutils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	diagnosticsTimeout      = 30 * time.Second
+	diagnosticsLogLines     = 500
+	diagnosticsBundleName   = "diagnostics.tar.gz"
+	diagnosticsSummaryName  = "diagnostics.md"
+	diagnosticsResponseSize = 64 * 1024
+)
+
+// diagnosticsBundle collects the files of a diagnostics bundle together with a human-readable summary.
+type diagnosticsBundle struct {
+	files   []diagnosticsFile
+	summary bytes.Buffer
+}
+
+type diagnosticsFile struct {
+	name string
+	data []byte
+}
+
+func (b *diagnosticsBundle) add(name string, data []byte) {
+	b.files = append(b.files, diagnosticsFile{name: name, data: data})
+}
+
+func (b *diagnosticsBundle) addYAML(name string, obj interface{}) {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		data = []byte(fmt.Sprintf("error marshalling: %v\n", err))
+	}
+	b.add(name, data)
+}
+
+func (b *diagnosticsBundle) printf(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(&b.summary, format, args...)
+}
+
+// collectDiagnostics collects a diagnostics bundle of the aggregated api servers of the extraction
+// after they did not become ready. The bundle is written as tarball plus summary into the run directory.
+func collectDiagnostics(
+	ctx context.Context,
+	log logr.Logger,
+	c client.Client,
+	clientSet *kubernetes.Clientset,
+	ext *envtestutils.EnvironmentExtensions,
+	gvs []schema.GroupVersion,
+	rec *extractionRecord,
+	cause error,
+) {
+	if ctx.Err() != nil {
+		// The extraction was interrupted, there is nothing to diagnose.
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, diagnosticsTimeout)
+	defer cancel()
+
+	log.Info("Collecting diagnostics")
+	b := &diagnosticsBundle{}
+	b.printf("# Diagnostics of target %s, control plane %s\n\nCollected at %s after:\n\n```\n%v\n```\n",
+		rec.Target, rec.ControlPlane, time.Now().Format(time.RFC3339), cause)
+
+	collectAPIServiceDiagnostics(ctx, b, c, ext.AllAPIServices())
+	for _, opts := range ext.AllAPIServiceInstallOptions() {
+		collectServerDiagnostics(ctx, b, c, opts)
+	}
+	collectOpenAPIDiagnostics(ctx, b, clientSet, gvs)
+	collectLogDiagnostics(b, rec)
+
+	dir := filepath.Dir(rec.logDir())
+	if err := b.write(dir); err != nil {
+		log.Error(err, "failed to write diagnostics")
+		return
+	}
+	rec.Diagnostics = filepath.Join(dir, diagnosticsBundleName)
+	log.Info("Wrote diagnostics", "Bundle", rec.Diagnostics, "Summary", filepath.Join(dir, diagnosticsSummaryName))
+}
+
+func collectAPIServiceDiagnostics(ctx context.Context, b *diagnosticsBundle, c client.Client, apiServices []*apiregistrationv1.APIService) {
+	b.printf("\n## APIServices\n\n| Name | Service | Condition | Status | Reason | Message |\n|---|---|---|---|---|---|\n")
+
+	list := &apiregistrationv1.APIServiceList{}
+	for _, desired := range apiServices {
+		apiService := &apiregistrationv1.APIService{}
+		if err := c.Get(ctx, client.ObjectKey{Name: desired.Name}, apiService); err != nil {
+			b.printf("| %s | | | | | error getting api service: %s |\n", desired.Name, markdownCell(err.Error()))
+			continue
+		}
+		list.Items = append(list.Items, *apiService)
+
+		service := ""
+		if ref := apiService.Spec.Service; ref != nil {
+			service = fmt.Sprintf("%s/%s", ref.Namespace, ref.Name)
+			if ref.Port != nil {
+				service = fmt.Sprintf("%s:%d", service, *ref.Port)
+			}
+		}
+		if len(apiService.Status.Conditions) == 0 {
+			b.printf("| %s | %s | | | | no conditions reported |\n", apiService.Name, service)
+		}
+		for _, cond := range apiService.Status.Conditions {
+			b.printf("| %s | %s | %s | %s | %s | %s |\n",
+				apiService.Name, service, cond.Type, cond.Status, cond.Reason, markdownCell(cond.Message))
+		}
+	}
+	b.addYAML("apiservices.yaml", list)
+}
+
+func collectServerDiagnostics(ctx context.Context, b *diagnosticsBundle, c client.Client, opts *envtestutils.APIServiceInstallOptions) {
+	name := fmt.Sprintf("%s/%s", opts.ServiceNamespace, opts.ServiceName)
+	prefix := fmt.Sprintf("servers/%s", opts.ServiceName)
+	b.printf("\n## Aggregated api server %s (%s:%d)\n\n", name, opts.LocalServingHost, opts.LocalServingPort)
+
+	service := &corev1.Service{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: opts.ServiceNamespace, Name: opts.ServiceName}, service); err != nil {
+		b.printf("* Service: error getting service: %v\n", err)
+	} else {
+		b.printf("* Service: type %s, external name %q\n", service.Spec.Type, service.Spec.ExternalName)
+		b.addYAML(prefix+"/service.yaml", service)
+	}
+
+	certPath := filepath.Join(opts.LocalServingCertDir, "tls.crt")
+	if sans, err := certificateSANs(certPath); err != nil {
+		b.printf("* Serving certificate: %v\n", err)
+	} else {
+		b.printf("* Serving certificate: %s\n", sans)
+		b.add(prefix+"/certificate.txt", []byte(sans+"\n"))
+	}
+
+	httpClient := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				// The diagnostics have to work with broken certificates as well, their SANs are recorded above.
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+	for _, endpoint := range []struct{ path, file string }{
+		{"/readyz?verbose", "readyz.txt"},
+		{"/healthz?verbose", "healthz.txt"},
+	} {
+		url := fmt.Sprintf("https://%s:%d%s", opts.LocalServingHost, opts.LocalServingPort, endpoint.path)
+		status, body, err := getURL(ctx, httpClient, url)
+		if err != nil {
+			b.printf("* `%s`: %v\n", endpoint.path, err)
+			b.add(prefix+"/"+endpoint.file, []byte(err.Error()+"\n"))
+			continue
+		}
+
+		b.printf("* `%s`: %s\n", endpoint.path, status)
+		for _, line := range strings.Split(string(body), "\n") {
+			if strings.HasPrefix(line, "[-]") {
+				b.printf("  * `%s`\n", line)
+			}
+		}
+		b.add(prefix+"/"+endpoint.file, body)
+	}
+}
+
+func getURL(ctx context.Context, httpClient *http.Client, url string) (string, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, diagnosticsResponseSize))
+	if err != nil {
+		return res.Status, nil, err
+	}
+	return res.Status, body, nil
+}
+
+// certificateSANs describes the subject alternative names and validity of the PEM certificate at path.
+func certificateSANs(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", fmt.Errorf("no PEM certificate found in %s", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("error parsing certificate: %w", err)
+	}
+
+	ips := make([]string, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	return fmt.Sprintf("DNS names %v, IP addresses %v, valid from %s until %s",
+		cert.DNSNames, ips, cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339)), nil
+}
+
+func collectOpenAPIDiagnostics(ctx context.Context, b *diagnosticsBundle, clientSet *kubernetes.Clientset, gvs []schema.GroupVersion) {
+	b.printf("\n## OpenAPI v3 index\n\n")
+
+	data, err := getPath(ctx, clientSet, "/openapi/v3")
+	if err != nil {
+		b.printf("Error getting index: %v\n", err)
+		return
+	}
+	b.add("openapi-v3-index.json", data)
+
+	var missing []string
+	for _, gv := range gvs {
+		if !bytes.Contains(data, []byte(fmt.Sprintf("%q", strings.TrimPrefix(groupVersionPath(gv), "/")))) {
+			missing = append(missing, gv.String())
+		}
+	}
+	if len(missing) == 0 {
+		b.printf("All group versions are listed in the index.\n")
+		return
+	}
+	b.printf("Group versions missing in the index: %s\n", strings.Join(missing, ", "))
+}
+
+func collectLogDiagnostics(b *diagnosticsBundle, rec *extractionRecord) {
+	b.printf("\n## Logs\n\n")
+	for _, file := range rec.logs {
+		b.printf("* %s: %s\n", file.Component(), file.Path())
+		b.add(fmt.Sprintf("logs/%s.log", file.Component()), []byte(strings.Join(file.Tail(diagnosticsLogLines), "\n")+"\n"))
+	}
+}
+
+func (b *diagnosticsBundle) write(dir string) error {
+	b.add(diagnosticsSummaryName, b.summary.Bytes())
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, file := range b.files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    filepath.ToSlash(filepath.Join("diagnostics", file.name)),
+			Mode:    0640,
+			Size:    int64(len(file.data)),
+			ModTime: now,
+		}); err != nil {
+			return fmt.Errorf("error writing tar header: %w", err)
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return fmt.Errorf("error writing tar entry: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("error closing tar writer: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("error closing gzip writer: %w", err)
+	}
+
+	if err := writeFile(dir, diagnosticsBundleName, buf.Bytes()); err != nil {
+		return err
+	}
+	return writeFile(dir, diagnosticsSummaryName, b.summary.Bytes())
+}
+
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(s)
+}
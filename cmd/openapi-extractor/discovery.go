@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	apidiscoveryv2 "k8s.io/api/apidiscovery/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	discoveryDir = "discovery"
+
+	legacyDiscoveryFileName     = "apis.json"
+	aggregatedDiscoveryFileName = "aggregated_apis.json"
+
+	apiGroupDiscoveryListKind = "APIGroupDiscoveryList"
+)
+
+// discoveryDocuments are the discovery documents of the extracted group versions.
+type discoveryDocuments struct {
+	// Groups is the legacy /apis group list, restricted to the extracted groups.
+	Groups *metav1.APIGroupList
+	// Resources are the legacy /apis/<group>/<version> resource lists per group version.
+	Resources map[schema.GroupVersion]*metav1.APIResourceList
+	// Aggregated is the aggregated discovery document (apidiscovery.k8s.io/v2),
+	// restricted to the extracted groups.
+	Aggregated *apidiscoveryv2.APIGroupDiscoveryList
+}
+
+func extractDiscovery(ctx context.Context, log logr.Logger, clientSet *kubernetes.Clientset, gvs []schema.GroupVersion) (*discoveryDocuments, error) {
+	log.Info("Extracting discovery")
+
+	groups := sets.New[string]()
+	for _, gv := range gvs {
+		groups.Insert(gv.Group)
+	}
+
+	groupList, err := extractLegacyGroupList(ctx, clientSet, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make(map[schema.GroupVersion]*metav1.APIResourceList, len(gvs))
+	for _, gv := range gvs {
+		path := groupVersionPath(gv)
+		data, err := getPath(ctx, clientSet, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get discovery path %s: %w", path, err)
+		}
+
+		resourceList := &metav1.APIResourceList{}
+		if err := json.Unmarshal(data, resourceList); err != nil {
+			return nil, fmt.Errorf("failed to parse discovery document %s: %w", path, err)
+		}
+		resources[gv] = resourceList
+	}
+
+	aggregated, err := extractAggregatedDiscovery(ctx, clientSet, groups)
+	if err != nil {
+		return nil, err
+	}
+
+	return &discoveryDocuments{
+		Groups:     groupList,
+		Resources:  resources,
+		Aggregated: aggregated,
+	}, nil
+}
+
+func extractLegacyGroupList(ctx context.Context, clientSet *kubernetes.Clientset, groups sets.Set[string]) (*metav1.APIGroupList, error) {
+	data, err := clientSet.RESTClient().Get().
+		AbsPath("/apis").
+		SetHeader("Accept", discovery.AcceptV1).
+		Do(ctx).
+		Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get legacy discovery: %w", err)
+	}
+
+	groupList := &metav1.APIGroupList{}
+	if err := json.Unmarshal(data, groupList); err != nil {
+		return nil, fmt.Errorf("failed to parse legacy discovery: %w", err)
+	}
+
+	var filtered []metav1.APIGroup
+	for _, group := range groupList.Groups {
+		if !groups.Has(group.Name) {
+			continue
+		}
+		// The server addresses contain the randomly allocated control plane port.
+		group.ServerAddressByClientCIDRs = nil
+		filtered = append(filtered, group)
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i].Name < filtered[j].Name
+	})
+	groupList.Groups = filtered
+	return groupList, nil
+}
+
+func extractAggregatedDiscovery(ctx context.Context, clientSet *kubernetes.Clientset, groups sets.Set[string]) (*apidiscoveryv2.APIGroupDiscoveryList, error) {
+	data, err := clientSet.RESTClient().Get().
+		AbsPath("/apis").
+		SetHeader("Accept", discovery.AcceptV2).
+		Do(ctx).
+		Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get aggregated discovery: %w", err)
+	}
+
+	discoveryList := &apidiscoveryv2.APIGroupDiscoveryList{}
+	if err := json.Unmarshal(data, discoveryList); err != nil {
+		return nil, fmt.Errorf("failed to parse aggregated discovery: %w", err)
+	}
+	if discoveryList.Kind != apiGroupDiscoveryListKind {
+		return nil, fmt.Errorf("server does not support aggregated discovery, got kind %q", discoveryList.Kind)
+	}
+
+	var filtered []apidiscoveryv2.APIGroupDiscovery
+	for _, group := range discoveryList.Items {
+		if groups.Has(group.Name) {
+			filtered = append(filtered, group)
+		}
+	}
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i].Name < filtered[j].Name
+	})
+	discoveryList.Items = filtered
+	return discoveryList, nil
+}
+
+func discoveryResourcesFileName(gv schema.GroupVersion) string {
+	return fmt.Sprintf("apis__%s__%s.json", gv.Group, gv.Version)
+}
+
+func writeDiscovery(dir string, docs *discoveryDocuments) error {
+	if err := writeJSONObject(dir, legacyDiscoveryFileName, docs.Groups); err != nil {
+		return fmt.Errorf("failed to write legacy discovery file: %w", err)
+	}
+
+	for gv, resourceList := range docs.Resources {
+		if err := writeJSONObject(dir, discoveryResourcesFileName(gv), resourceList); err != nil {
+			return fmt.Errorf("failed to write legacy discovery file for %s: %w", gv, err)
+		}
+	}
+
+	if err := writeJSONObject(dir, aggregatedDiscoveryFileName, docs.Aggregated); err != nil {
+		return fmt.Errorf("failed to write aggregated discovery file: %w", err)
+	}
+	return nil
+}
+
+func writeJSONObject(dir string, name string, obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", name, err)
+	}
+	return writeJSONFile(dir, name, data)
+}
@@ -0,0 +1,680 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+const (
+	fakeAPIServerGitVersion = "v0.0.0-openapi-extractor-fake"
+
+	aggregatedDiscoveryContentType = "application/json;g=apidiscovery.k8s.io;v=v2;as=APIGroupDiscoveryList"
+)
+
+// fakeAPIServer serves the discovery documents and OpenAPI specs of an extraction together with an in-memory
+// storage for every resource in them. Objects are validated against the schemas of the OpenAPI v3 documents.
+// It is a stand-in for the aggregated api server in client tests, not a faithful implementation: there is
+// no watch, no conversion between versions and no admission.
+type fakeAPIServer struct {
+	spec *specServer
+
+	groups        *metav1.APIGroupList
+	resourceLists map[schema.GroupVersion]*metav1.APIResourceList
+	aggregated    []byte
+	resources     map[schema.GroupVersionResource]*fakeResource
+
+	mu              sync.Mutex
+	objects         map[fakeObjectKey]*unstructured.Unstructured
+	resourceVersion uint64
+}
+
+// fakeResource is a resource served by the fake api server.
+type fakeResource struct {
+	gvr        schema.GroupVersionResource
+	kind       string
+	namespaced bool
+	verbs      sets.Set[string]
+	hasStatus  bool
+
+	// schema is the schema of the kind within doc, nil if the OpenAPI v3 document does not define it.
+	schema map[string]interface{}
+	doc    map[string]interface{}
+}
+
+type fakeObjectKey struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+// newFakeAPIServer loads the discovery documents and the OpenAPI v3 documents of the extraction in s.dir.
+func newFakeAPIServer(s *specServer) (*fakeAPIServer, error) {
+	dir := filepath.Join(s.dir, s.layout.DiscoveryDir)
+	f := &fakeAPIServer{
+		spec:          s,
+		groups:        &metav1.APIGroupList{},
+		resourceLists: make(map[schema.GroupVersion]*metav1.APIResourceList),
+		resources:     make(map[schema.GroupVersionResource]*fakeResource),
+		objects:       make(map[fakeObjectKey]*unstructured.Unstructured),
+	}
+	if err := readJSONFile(filepath.Join(dir, legacyDiscoveryFileName), f.groups); err != nil {
+		return nil, fmt.Errorf("failed to read discovery, the specs have to be extracted without --skip-discovery: %w", err)
+	}
+	aggregated, err := os.ReadFile(filepath.Join(dir, aggregatedDiscoveryFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read aggregated discovery: %w", err)
+	}
+	f.aggregated = aggregated
+
+	v3Files, err := s.v3Files()
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range f.groups.Groups {
+		for _, groupVersion := range group.Versions {
+			gv := schema.GroupVersion{Group: group.Name, Version: groupVersion.Version}
+			resourceList := &metav1.APIResourceList{}
+			if err := readJSONFile(filepath.Join(dir, discoveryResourcesFileName(gv)), resourceList); err != nil {
+				return nil, fmt.Errorf("failed to read discovery of %s: %w", gv, err)
+			}
+			f.resourceLists[gv] = resourceList
+
+			var doc map[string]interface{}
+			if file, ok := v3Files[strings.TrimPrefix(groupVersionPath(gv), "/")]; ok {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read OpenAPI v3 document of %s: %w", gv, err)
+				}
+				if doc, err = parseSpecDocument(data); err != nil {
+					return nil, fmt.Errorf("failed to parse OpenAPI v3 document of %s: %w", gv, err)
+				}
+			}
+			f.addResources(gv, resourceList, doc)
+		}
+	}
+	return f, nil
+}
+
+func (f *fakeAPIServer) addResources(gv schema.GroupVersion, resourceList *metav1.APIResourceList, doc map[string]interface{}) {
+	for _, apiResource := range resourceList.APIResources {
+		if strings.Contains(apiResource.Name, "/") {
+			continue
+		}
+		gvk := gv.WithKind(apiResource.Kind)
+		f.resources[gv.WithResource(apiResource.Name)] = &fakeResource{
+			gvr:        gv.WithResource(apiResource.Name),
+			kind:       apiResource.Kind,
+			namespaced: apiResource.Namespaced,
+			verbs:      sets.New[string](apiResource.Verbs...),
+			schema:     kindSchema(doc, gvk),
+			doc:        doc,
+		}
+	}
+	for _, apiResource := range resourceList.APIResources {
+		name, subresource, ok := strings.Cut(apiResource.Name, "/")
+		if res, exists := f.resources[gv.WithResource(name)]; ok && exists && subresource == "status" {
+			res.hasStatus = true
+		}
+	}
+}
+
+// kindSchema returns the schema of the OpenAPI v3 document declaring the given kind.
+func kindSchema(doc map[string]interface{}, gvk schema.GroupVersionKind) map[string]interface{} {
+	for _, value := range lookupObject(doc, "components", "schemas") {
+		s, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		gvks, _ := s["x-kubernetes-group-version-kind"].([]interface{})
+		for _, value := range gvks {
+			declared, _ := value.(map[string]interface{})
+			if declared["group"] == gvk.Group && declared["version"] == gvk.Version && declared["kind"] == gvk.Kind {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+func readJSONFile(path string, obj interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, obj)
+}
+
+func (f *fakeAPIServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", f.spec.handler())
+	mux.HandleFunc("GET /version", f.serveVersion)
+	mux.HandleFunc("GET /api", f.serveCoreVersions)
+	mux.HandleFunc("GET /apis", f.serveGroups)
+	mux.HandleFunc("/apis/", f.serveAPIs)
+	return mux
+}
+
+func (f *fakeAPIServer) serveVersion(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, &version.Info{GitVersion: fakeAPIServerGitVersion})
+}
+
+// serveCoreVersions serves the legacy discovery of the core group, which is never extracted.
+func (f *fakeAPIServer) serveCoreVersions(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, &metav1.APIVersions{
+		TypeMeta: metav1.TypeMeta{Kind: "APIVersions"},
+		Versions: []string{},
+	})
+}
+
+func (f *fakeAPIServer) serveGroups(w http.ResponseWriter, r *http.Request) {
+	if f.aggregated != nil && strings.Contains(r.Header.Get("Accept"), "as=APIGroupDiscoveryList") {
+		w.Header().Set("Content-Type", aggregatedDiscoveryContentType)
+		_, _ = w.Write(f.aggregated)
+		return
+	}
+	writeJSON(w, http.StatusOK, f.groups)
+}
+
+// serveAPIs serves the discovery of the groups and versions and the requests for their resources.
+func (f *fakeAPIServer) serveAPIs(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/apis/"), "/"), "/")
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		for i := range f.groups.Groups {
+			if group := f.groups.Groups[i]; group.Name == parts[0] {
+				group.TypeMeta = metav1.TypeMeta{Kind: "APIGroup", APIVersion: "v1"}
+				writeJSON(w, http.StatusOK, &group)
+				return
+			}
+		}
+		writeAPIError(w, apierrors.NewNotFound(schema.GroupResource{}, r.URL.Path))
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		if resourceList, ok := f.resourceLists[schema.GroupVersion{Group: parts[0], Version: parts[1]}]; ok {
+			writeJSON(w, http.StatusOK, resourceList)
+			return
+		}
+		writeAPIError(w, apierrors.NewNotFound(schema.GroupResource{}, r.URL.Path))
+	case len(parts) > 2:
+		req, err := f.parseResourceRequest(schema.GroupVersion{Group: parts[0], Version: parts[1]}, parts[2:])
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		f.serveResource(w, r, req)
+	default:
+		writeAPIError(w, apierrors.NewMethodNotSupported(schema.GroupResource{}, r.Method))
+	}
+}
+
+// fakeRequest is a request for a resource, a single object or its status.
+type fakeRequest struct {
+	res         *fakeResource
+	namespace   string
+	name        string
+	subresource string
+}
+
+func (f *fakeAPIServer) parseResourceRequest(gv schema.GroupVersion, parts []string) (*fakeRequest, error) {
+	req := &fakeRequest{}
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		if res, ok := f.resources[gv.WithResource(parts[2])]; ok && res.namespaced {
+			req.namespace = parts[1]
+			parts = parts[2:]
+		}
+	}
+
+	res, ok := f.resources[gv.WithResource(parts[0])]
+	if !ok || len(parts) > 3 {
+		return nil, apierrors.NewNotFound(gv.WithResource(parts[0]).GroupResource(), "")
+	}
+	req.res = res
+	if len(parts) > 1 {
+		if res.namespaced && req.namespace == "" {
+			return nil, apierrors.NewNotFound(res.gvr.GroupResource(), parts[1])
+		}
+		req.name = parts[1]
+	}
+	if len(parts) > 2 {
+		if parts[2] != "status" || !res.hasStatus {
+			return nil, apierrors.NewNotFound(res.gvr.GroupResource(), req.name)
+		}
+		req.subresource = parts[2]
+	}
+	return req, nil
+}
+
+func (f *fakeAPIServer) serveResource(w http.ResponseWriter, r *http.Request, req *fakeRequest) {
+	verb := requestVerb(r, req)
+	if req.subresource == "" && !req.res.verbs.Has(verb) {
+		writeAPIError(w, apierrors.NewMethodNotSupported(req.res.gvr.GroupResource(), verb))
+		return
+	}
+
+	var (
+		obj    runtime.Object
+		status = http.StatusOK
+		err    error
+	)
+	f.mu.Lock()
+	switch verb {
+	case "list":
+		obj, err = f.list(r, req)
+	case "deletecollection":
+		obj, err = f.deleteCollection(r, req)
+	case "create":
+		obj, err = f.create(w, r, req)
+		status = http.StatusCreated
+	case "get":
+		obj, err = f.get(req)
+	case "update":
+		obj, err = f.update(w, r, req)
+	case "patch":
+		obj, err = f.patch(w, r, req)
+	case "delete":
+		obj, err = f.delete(r, req)
+	default:
+		err = apierrors.NewMethodNotSupported(req.res.gvr.GroupResource(), verb)
+	}
+	f.mu.Unlock()
+
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, status, obj)
+}
+
+func requestVerb(r *http.Request, req *fakeRequest) string {
+	switch r.Method {
+	case http.MethodGet:
+		if req.name != "" {
+			return "get"
+		}
+		if r.URL.Query().Get("watch") == "true" {
+			return "watch"
+		}
+		return "list"
+	case http.MethodPost:
+		if req.name == "" {
+			return "create"
+		}
+	case http.MethodPut:
+		if req.name != "" {
+			return "update"
+		}
+	case http.MethodPatch:
+		if req.name != "" {
+			return "patch"
+		}
+	case http.MethodDelete:
+		if req.name != "" {
+			return "delete"
+		}
+		return "deletecollection"
+	}
+	return strings.ToLower(r.Method)
+}
+
+func (f *fakeAPIServer) key(req *fakeRequest, name string) fakeObjectKey {
+	return fakeObjectKey{gvr: req.res.gvr, namespace: req.namespace, name: name}
+}
+
+func (f *fakeAPIServer) nextResourceVersion() string {
+	f.resourceVersion++
+	return strconv.FormatUint(f.resourceVersion, 10)
+}
+
+func (f *fakeAPIServer) get(req *fakeRequest) (runtime.Object, error) {
+	obj, ok := f.objects[f.key(req, req.name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(req.res.gvr.GroupResource(), req.name)
+	}
+	return obj, nil
+}
+
+// matching returns the objects of the request matching its label and field selectors, sorted by namespace and name.
+func (f *fakeAPIServer) matching(r *http.Request, req *fakeRequest) ([]fakeObjectKey, error) {
+	labelSelector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	fieldSelector, err := fields.ParseSelector(r.URL.Query().Get("fieldSelector"))
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+
+	var keys []fakeObjectKey
+	for key, obj := range f.objects {
+		if key.gvr != req.res.gvr || (req.namespace != "" && key.namespace != req.namespace) {
+			continue
+		}
+		objFields := fields.Set{"metadata.name": key.name, "metadata.namespace": key.namespace}
+		if labelSelector.Matches(labels.Set(obj.GetLabels())) && fieldSelector.Matches(objFields) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].namespace != keys[j].namespace {
+			return keys[i].namespace < keys[j].namespace
+		}
+		return keys[i].name < keys[j].name
+	})
+	return keys, nil
+}
+
+func (f *fakeAPIServer) newList(req *fakeRequest, keys []fakeObjectKey) *unstructured.UnstructuredList {
+	list := &unstructured.UnstructuredList{Items: []unstructured.Unstructured{}}
+	list.SetAPIVersion(req.res.gvr.GroupVersion().String())
+	list.SetKind(req.res.kind + "List")
+	list.SetResourceVersion(strconv.FormatUint(f.resourceVersion, 10))
+	for _, key := range keys {
+		list.Items = append(list.Items, *f.objects[key].DeepCopy())
+	}
+	return list
+}
+
+func (f *fakeAPIServer) list(r *http.Request, req *fakeRequest) (runtime.Object, error) {
+	keys, err := f.matching(r, req)
+	if err != nil {
+		return nil, err
+	}
+	return f.newList(req, keys), nil
+}
+
+func (f *fakeAPIServer) deleteCollection(r *http.Request, req *fakeRequest) (runtime.Object, error) {
+	keys, err := f.matching(r, req)
+	if err != nil {
+		return nil, err
+	}
+	list := f.newList(req, keys)
+	if !isDryRun(r) {
+		for _, key := range keys {
+			delete(f.objects, key)
+		}
+	}
+	return list, nil
+}
+
+func (f *fakeAPIServer) delete(r *http.Request, req *fakeRequest) (runtime.Object, error) {
+	key := f.key(req, req.name)
+	obj, ok := f.objects[key]
+	if !ok {
+		return nil, apierrors.NewNotFound(req.res.gvr.GroupResource(), req.name)
+	}
+	if !isDryRun(r) {
+		delete(f.objects, key)
+	}
+	return obj, nil
+}
+
+func (f *fakeAPIServer) create(w http.ResponseWriter, r *http.Request, req *fakeRequest) (runtime.Object, error) {
+	if req.res.namespaced && req.namespace == "" {
+		return nil, apierrors.NewBadRequest("the namespace of the object has to be sent on the request")
+	}
+	obj, err := decodeObject(r, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.GetName() == "" {
+		if obj.GetGenerateName() == "" {
+			return nil, apierrors.NewInvalid(req.res.gvr.GroupVersion().WithKind(req.res.kind).GroupKind(), "",
+				field.ErrorList{field.Required(field.NewPath("metadata", "name"), "name or generateName is required")})
+		}
+		obj.SetName(obj.GetGenerateName() + utilrand.String(5))
+	}
+	key := f.key(req, obj.GetName())
+	if _, ok := f.objects[key]; ok {
+		return nil, apierrors.NewAlreadyExists(req.res.gvr.GroupResource(), obj.GetName())
+	}
+
+	// Like for CRDs, the status is only set via the status subresource.
+	if req.res.hasStatus {
+		unstructured.RemoveNestedField(obj.Object, "status")
+	}
+	obj.SetUID(types.UID(uuid.NewUUID()))
+	obj.SetCreationTimestamp(metav1.NewTime(time.Now()))
+	obj.SetGeneration(1)
+	obj.SetResourceVersion("")
+	if err := validateFakeObject(w, r, req.res, obj); err != nil {
+		return nil, err
+	}
+
+	if !isDryRun(r) {
+		obj.SetResourceVersion(f.nextResourceVersion())
+		f.objects[key] = obj
+	}
+	return obj, nil
+}
+
+func (f *fakeAPIServer) update(w http.ResponseWriter, r *http.Request, req *fakeRequest) (runtime.Object, error) {
+	old, ok := f.objects[f.key(req, req.name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(req.res.gvr.GroupResource(), req.name)
+	}
+	obj, err := decodeObject(r, req)
+	if err != nil {
+		return nil, err
+	}
+	return f.store(w, r, req, old, obj)
+}
+
+func (f *fakeAPIServer) patch(w http.ResponseWriter, r *http.Request, req *fakeRequest) (runtime.Object, error) {
+	old, ok := f.objects[f.key(req, req.name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(req.res.gvr.GroupResource(), req.name)
+	}
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	oldData, err := json.Marshal(old)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+
+	var patched []byte
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch types.PatchType(mediaType) {
+	case types.MergePatchType, types.StrategicMergePatchType:
+		// Without Go types, strategic merge patches are applied as merge patches.
+		patched, err = jsonpatch.MergePatch(oldData, patch)
+	case types.JSONPatchType:
+		var decoded jsonpatch.Patch
+		if decoded, err = jsonpatch.DecodePatch(patch); err == nil {
+			patched, err = decoded.Apply(oldData)
+		}
+	default:
+		return nil, &apierrors.StatusError{ErrStatus: metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusUnsupportedMediaType,
+			Reason:  metav1.StatusReasonUnsupportedMediaType,
+			Message: fmt.Sprintf("the fake api server does not support patches of type %q", mediaType),
+		}}
+	}
+	if err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("failed to apply patch: %v", err))
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(patched); err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	return f.store(w, r, req, old, obj)
+}
+
+// store stores the updated object, or only its status for requests of the status subresource.
+func (f *fakeAPIServer) store(w http.ResponseWriter, r *http.Request, req *fakeRequest, old, obj *unstructured.Unstructured) (runtime.Object, error) {
+	if obj.GetName() != req.name {
+		return nil, apierrors.NewBadRequest("the name of the object does not match the name on the URL")
+	}
+	if rv := obj.GetResourceVersion(); rv != "" && rv != old.GetResourceVersion() {
+		return nil, apierrors.NewConflict(req.res.gvr.GroupResource(), req.name,
+			errors.New("the object has been modified; please apply your changes to the latest version and try again"))
+	}
+
+	updated := obj
+	switch {
+	case req.subresource == "status":
+		updated = old.DeepCopy()
+		if status, ok := obj.Object["status"]; ok {
+			updated.Object["status"] = status
+		} else {
+			delete(updated.Object, "status")
+		}
+	case req.res.hasStatus:
+		if status, ok := old.Object["status"]; ok {
+			updated.Object["status"] = runtime.DeepCopyJSONValue(status)
+		} else {
+			delete(updated.Object, "status")
+		}
+	}
+
+	updated.SetUID(old.GetUID())
+	updated.SetCreationTimestamp(old.GetCreationTimestamp())
+	updated.SetGeneration(old.GetGeneration())
+	if !reflect.DeepEqual(withoutMetadataAndStatus(old), withoutMetadataAndStatus(updated)) {
+		updated.SetGeneration(old.GetGeneration() + 1)
+	}
+	if err := validateFakeObject(w, r, req.res, updated); err != nil {
+		return nil, err
+	}
+
+	if isDryRun(r) {
+		return updated, nil
+	}
+	updated.SetResourceVersion(f.nextResourceVersion())
+	f.objects[f.key(req, req.name)] = updated
+	return updated, nil
+}
+
+func withoutMetadataAndStatus(obj *unstructured.Unstructured) map[string]interface{} {
+	res := make(map[string]interface{}, len(obj.Object))
+	for key, value := range obj.Object {
+		if key != "metadata" && key != "status" {
+			res[key] = value
+		}
+	}
+	return res
+}
+
+// decodeObject decodes the object in the request body, defaulting its apiVersion, kind and namespace.
+func decodeObject(r *http.Request, req *fakeRequest) (*unstructured.Unstructured, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	if err := json.Unmarshal(data, &obj.Object); err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("failed to decode object: %v", err))
+	}
+
+	apiVersion := req.res.gvr.GroupVersion().String()
+	switch obj.GetAPIVersion() {
+	case "":
+		obj.SetAPIVersion(apiVersion)
+	case apiVersion:
+	default:
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("apiVersion %s does not match %s", obj.GetAPIVersion(), apiVersion))
+	}
+	switch obj.GetKind() {
+	case "":
+		obj.SetKind(req.res.kind)
+	case req.res.kind:
+	default:
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("kind %s does not match %s", obj.GetKind(), req.res.kind))
+	}
+
+	switch {
+	case !req.res.namespaced:
+		if obj.GetNamespace() != "" {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("%s is not namespaced", req.res.gvr.Resource))
+		}
+	case obj.GetNamespace() == "":
+		obj.SetNamespace(req.namespace)
+	case obj.GetNamespace() != req.namespace:
+		return nil, apierrors.NewBadRequest("the namespace of the provided object does not match the namespace sent on the request")
+	}
+	return obj, nil
+}
+
+// validateFakeObject validates the object against the schema of its kind. Fields not declared in the
+// schema are handled according to the fieldValidation parameter of the request, defaulting to a warning.
+func validateFakeObject(w http.ResponseWriter, r *http.Request, res *fakeResource, obj *unstructured.Unstructured) error {
+	if res.schema == nil {
+		return nil
+	}
+	v := &schemaValidator{doc: res.doc}
+	if errs := v.validate(nil, res.schema, obj.Object); len(errs) > 0 {
+		return apierrors.NewInvalid(schema.GroupKind{Group: res.gvr.Group, Kind: res.kind}, obj.GetName(), errs)
+	}
+
+	switch r.URL.Query().Get("fieldValidation") {
+	case metav1.FieldValidationIgnore:
+	case metav1.FieldValidationStrict:
+		if len(v.unknownFields) > 0 {
+			return apierrors.NewBadRequest(fmt.Sprintf("strict decoding error: unknown field %q", strings.Join(v.unknownFields, `", "`)))
+		}
+	default:
+		for _, unknown := range v.unknownFields {
+			w.Header().Add("Warning", fmt.Sprintf("299 - %q", fmt.Sprintf("unknown field %q", unknown)))
+		}
+	}
+	return nil
+}
+
+func isDryRun(r *http.Request) bool {
+	for _, value := range r.URL.Query()["dryRun"] {
+		if value == metav1.DryRunAll {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(obj)
+}
+
+// writeAPIError writes the error as metav1.Status like the kube-apiserver.
+func writeAPIError(w http.ResponseWriter, err error) {
+	var apiStatus apierrors.APIStatus
+	if !errors.As(err, &apiStatus) {
+		apiStatus = apierrors.NewInternalError(err)
+	}
+	status := apiStatus.Status()
+	status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+	writeJSON(w, int(status.Code), &status)
+}
@@ -0,0 +1,530 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var testGroupVersion = schema.GroupVersion{Group: "example.ironcore.dev", Version: "v1alpha1"}
+
+// testOpenAPIV3Document declares the schemas of the namespaced Widget with a status subresource and the
+// cluster-scoped Gadget without one.
+const testOpenAPIV3Document = `{
+  "openapi": "3.0.0",
+  "components": {
+    "schemas": {
+      "ObjectMeta": {"type": "object"},
+      "WidgetSpec": {
+        "type": "object",
+        "required": ["size"],
+        "properties": {
+          "size": {"type": "string", "enum": ["small", "large"]},
+          "replicas": {"type": "integer"}
+        }
+      },
+      "Widget": {
+        "type": "object",
+        "properties": {
+          "apiVersion": {"type": "string"},
+          "kind": {"type": "string"},
+          "metadata": {"allOf": [{"$ref": "#/components/schemas/ObjectMeta"}]},
+          "spec": {"allOf": [{"$ref": "#/components/schemas/WidgetSpec"}]},
+          "status": {"type": "object", "properties": {"phase": {"type": "string"}}}
+        },
+        "x-kubernetes-group-version-kind": [{"group": "example.ironcore.dev", "version": "v1alpha1", "kind": "Widget"}]
+      },
+      "Gadget": {
+        "type": "object",
+        "properties": {
+          "apiVersion": {"type": "string"},
+          "kind": {"type": "string"},
+          "metadata": {"allOf": [{"$ref": "#/components/schemas/ObjectMeta"}]},
+          "spec": {"type": "object", "properties": {"port": {"x-kubernetes-int-or-string": true}}},
+          "status": {"type": "object", "properties": {"phase": {"type": "string"}}}
+        },
+        "x-kubernetes-group-version-kind": [{"group": "example.ironcore.dev", "version": "v1alpha1", "kind": "Gadget"}]
+      }
+    }
+  }
+}`
+
+// newTestFakeAPIServer writes a small extraction in the default layout and serves it by a fake api server.
+func newTestFakeAPIServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	layout := outputConfig{}
+	setOutputConfigDefaults(&layout)
+
+	verbs := metav1.Verbs{"create", "delete", "deletecollection", "get", "list", "patch", "update", "watch"}
+	groups := &metav1.APIGroupList{Groups: []metav1.APIGroup{{
+		Name: testGroupVersion.Group,
+		Versions: []metav1.GroupVersionForDiscovery{{
+			GroupVersion: testGroupVersion.String(),
+			Version:      testGroupVersion.Version,
+		}},
+	}}}
+	resourceList := &metav1.APIResourceList{
+		GroupVersion: testGroupVersion.String(),
+		APIResources: []metav1.APIResource{
+			{Name: "widgets", Kind: "Widget", Namespaced: true, Verbs: verbs},
+			{Name: "widgets/status", Kind: "Widget", Namespaced: true, Verbs: metav1.Verbs{"get", "patch", "update"}},
+			{Name: "gadgets", Kind: "Gadget", Verbs: verbs},
+		},
+	}
+	discoveryDir := filepath.Join(dir, layout.DiscoveryDir)
+	writeTestJSON(t, filepath.Join(discoveryDir, legacyDiscoveryFileName), groups)
+	writeTestJSON(t, filepath.Join(discoveryDir, discoveryResourcesFileName(testGroupVersion)), resourceList)
+	v3File := filepath.Join(dir, layout.V3Dir, "apis__"+testGroupVersion.Group+"__"+testGroupVersion.Version+"_openapi.json")
+	writeTestFile(t, v3File, []byte(testOpenAPIV3Document))
+
+	f, err := newFakeAPIServer(&specServer{dir: dir, layout: layout})
+	if err != nil {
+		t.Fatalf("failed to create fake api server: %v", err)
+	}
+	server := httptest.NewServer(f.handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeTestJSON(t *testing.T, path string, obj interface{}) {
+	t.Helper()
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, data)
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeRequestStep is a request to the fake api server together with its expected outcome.
+type fakeRequestStep struct {
+	method      string
+	path        string
+	contentType string
+	body        string
+
+	status  int
+	warning string
+	check   func(t *testing.T, obj *unstructured.Unstructured)
+}
+
+func (s fakeRequestStep) run(t *testing.T, server *httptest.Server) {
+	t.Helper()
+	req, err := http.NewRequest(s.method, server.URL+s.path, strings.NewReader(s.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contentType := s.contentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != s.status {
+		t.Fatalf("%s %s: status = %d, want %d: %s", s.method, s.path, resp.StatusCode, s.status, data)
+	}
+	if warning := resp.Header.Get("Warning"); !strings.Contains(warning, s.warning) || (s.warning == "" && warning != "") {
+		t.Errorf("%s %s: warning = %q, want %q", s.method, s.path, warning, s.warning)
+	}
+	if s.check != nil {
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(data); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", s.method, s.path, err)
+		}
+		s.check(t, obj)
+	}
+}
+
+func expectField(t *testing.T, obj *unstructured.Unstructured, want interface{}, fields ...string) {
+	t.Helper()
+	got, found, err := unstructured.NestedFieldNoCopy(obj.Object, fields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		got = nil
+	}
+	if got != want {
+		t.Errorf("%s = %v (%T), want %v (%T)", strings.Join(fields, "."), got, got, want, want)
+	}
+}
+
+func TestFakeAPIServerResources(t *testing.T) {
+	const (
+		widgets = "/apis/example.ironcore.dev/v1alpha1/namespaces/default/widgets"
+		gadgets = "/apis/example.ironcore.dev/v1alpha1/gadgets"
+	)
+	createWidget := fakeRequestStep{
+		method: http.MethodPost,
+		path:   widgets,
+		body:   `{"metadata": {"name": "foo"}, "spec": {"size": "small", "replicas": 1}}`,
+		status: http.StatusCreated,
+	}
+	createGadget := fakeRequestStep{
+		method: http.MethodPost,
+		path:   gadgets,
+		body:   `{"metadata": {"name": "bar"}, "spec": {"port": "http"}, "status": {"phase": "Ready"}}`,
+		status: http.StatusCreated,
+	}
+
+	tests := []struct {
+		name  string
+		steps []fakeRequestStep
+	}{
+		{
+			name: "create namespaced",
+			steps: []fakeRequestStep{{
+				method: http.MethodPost,
+				path:   widgets,
+				body:   `{"metadata": {"name": "foo"}, "spec": {"size": "small"}, "status": {"phase": "Ready"}}`,
+				status: http.StatusCreated,
+				check: func(t *testing.T, obj *unstructured.Unstructured) {
+					expectField(t, obj, "example.ironcore.dev/v1alpha1", "apiVersion")
+					expectField(t, obj, "Widget", "kind")
+					expectField(t, obj, "default", "metadata", "namespace")
+					expectField(t, obj, "1", "metadata", "resourceVersion")
+					expectField(t, obj, int64(1), "metadata", "generation")
+					expectField(t, obj, nil, "status")
+				},
+			}},
+		},
+		{
+			name: "create namespaced without namespace",
+			steps: []fakeRequestStep{{
+				method: http.MethodPost,
+				path:   "/apis/example.ironcore.dev/v1alpha1/widgets",
+				body:   `{"metadata": {"name": "foo"}, "spec": {"size": "small"}}`,
+				status: http.StatusBadRequest,
+			}},
+		},
+		{
+			name: "get namespaced",
+			steps: []fakeRequestStep{createWidget, {
+				method: http.MethodGet,
+				path:   widgets + "/foo",
+				status: http.StatusOK,
+				check: func(t *testing.T, obj *unstructured.Unstructured) {
+					expectField(t, obj, "foo", "metadata", "name")
+				},
+			}},
+		},
+		{
+			name: "get namespaced without namespace",
+			steps: []fakeRequestStep{createWidget, {
+				method: http.MethodGet,
+				path:   "/apis/example.ironcore.dev/v1alpha1/widgets/foo",
+				status: http.StatusNotFound,
+			}},
+		},
+		{
+			name: "get namespaced in other namespace",
+			steps: []fakeRequestStep{createWidget, {
+				method: http.MethodGet,
+				path:   "/apis/example.ironcore.dev/v1alpha1/namespaces/other/widgets/foo",
+				status: http.StatusNotFound,
+			}},
+		},
+		{
+			name: "list namespaced across namespaces",
+			steps: []fakeRequestStep{createWidget, {
+				method: http.MethodGet,
+				path:   "/apis/example.ironcore.dev/v1alpha1/widgets",
+				status: http.StatusOK,
+				check: func(t *testing.T, obj *unstructured.Unstructured) {
+					expectField(t, obj, "WidgetList", "kind")
+					items, _, _ := unstructured.NestedSlice(obj.Object, "items")
+					if len(items) != 1 {
+						t.Errorf("got %d items, want 1", len(items))
+					}
+				},
+			}},
+		},
+		{
+			name: "create and get cluster-scoped",
+			steps: []fakeRequestStep{createGadget, {
+				method: http.MethodGet,
+				path:   gadgets + "/bar",
+				status: http.StatusOK,
+				check: func(t *testing.T, obj *unstructured.Unstructured) {
+					expectField(t, obj, nil, "metadata", "namespace")
+					// Without a status subresource, the status is part of the object.
+					expectField(t, obj, "Ready", "status", "phase")
+				},
+			}},
+		},
+		{
+			name: "cluster-scoped within namespace",
+			steps: []fakeRequestStep{createGadget, {
+				method: http.MethodGet,
+				path:   "/apis/example.ironcore.dev/v1alpha1/namespaces/default/gadgets/bar",
+				status: http.StatusNotFound,
+			}},
+		},
+		{
+			name: "create cluster-scoped with namespace",
+			steps: []fakeRequestStep{{
+				method: http.MethodPost,
+				path:   gadgets,
+				body:   `{"metadata": {"name": "bar", "namespace": "default"}}`,
+				status: http.StatusBadRequest,
+			}},
+		},
+		{
+			name: "update status subresource",
+			steps: []fakeRequestStep{createWidget, {
+				method: http.MethodPut,
+				path:   widgets + "/foo/status",
+				body:   `{"metadata": {"name": "foo"}, "spec": {"size": "large"}, "status": {"phase": "Ready"}}`,
+				status: http.StatusOK,
+				check: func(t *testing.T, obj *unstructured.Unstructured) {
+					expectField(t, obj, "Ready", "status", "phase")
+					expectField(t, obj, "small", "spec", "size")
+					expectField(t, obj, int64(1), "metadata", "generation")
+					expectField(t, obj, "2", "metadata", "resourceVersion")
+				},
+			}},
+		},
+		{
+			name: "update ignores status",
+			steps: []fakeRequestStep{createWidget, {
+				method: http.MethodPut,
+				path:   widgets + "/foo",
+				body:   `{"metadata": {"name": "foo"}, "spec": {"size": "large"}, "status": {"phase": "Ready"}}`,
+				status: http.StatusOK,
+				check: func(t *testing.T, obj *unstructured.Unstructured) {
+					expectField(t, obj, nil, "status")
+					expectField(t, obj, "large", "spec", "size")
+					expectField(t, obj, int64(2), "metadata", "generation")
+				},
+			}},
+		},
+		{
+			name: "status of resource without status subresource",
+			steps: []fakeRequestStep{createGadget, {
+				method: http.MethodGet,
+				path:   gadgets + "/bar/status",
+				status: http.StatusNotFound,
+			}},
+		},
+		{
+			name: "update with stale resourceVersion",
+			steps: []fakeRequestStep{createWidget, {
+				method: http.MethodPut,
+				path:   widgets + "/foo",
+				body:   `{"metadata": {"name": "foo", "resourceVersion": "1"}, "spec": {"size": "large"}}`,
+				status: http.StatusOK,
+			}, {
+				method: http.MethodPut,
+				path:   widgets + "/foo",
+				body:   `{"metadata": {"name": "foo", "resourceVersion": "1"}, "spec": {"size": "small"}}`,
+				status: http.StatusConflict,
+			}},
+		},
+		{
+			name: "merge patch",
+			steps: []fakeRequestStep{createWidget, {
+				method:      http.MethodPatch,
+				path:        widgets + "/foo",
+				contentType: "application/merge-patch+json",
+				body:        `{"spec": {"replicas": 3}}`,
+				status:      http.StatusOK,
+				check: func(t *testing.T, obj *unstructured.Unstructured) {
+					expectField(t, obj, int64(3), "spec", "replicas")
+					expectField(t, obj, "small", "spec", "size")
+					expectField(t, obj, int64(2), "metadata", "generation")
+				},
+			}},
+		},
+		{
+			name: "json patch",
+			steps: []fakeRequestStep{createWidget, {
+				method:      http.MethodPatch,
+				path:        widgets + "/foo",
+				contentType: "application/json-patch+json",
+				body:        `[{"op": "replace", "path": "/spec/size", "value": "large"}, {"op": "remove", "path": "/spec/replicas"}]`,
+				status:      http.StatusOK,
+				check: func(t *testing.T, obj *unstructured.Unstructured) {
+					expectField(t, obj, "large", "spec", "size")
+					expectField(t, obj, nil, "spec", "replicas")
+				},
+			}},
+		},
+		{
+			name: "json patch with stale resourceVersion",
+			steps: []fakeRequestStep{createWidget, {
+				method:      http.MethodPatch,
+				path:        widgets + "/foo",
+				contentType: "application/json-patch+json",
+				body:        `[{"op": "replace", "path": "/metadata/resourceVersion", "value": "0"}]`,
+				status:      http.StatusConflict,
+			}},
+		},
+		{
+			name: "apply patch",
+			steps: []fakeRequestStep{createWidget, {
+				method:      http.MethodPatch,
+				path:        widgets + "/foo",
+				contentType: "application/apply-patch+yaml",
+				body:        `spec: {replicas: 3}`,
+				status:      http.StatusUnsupportedMediaType,
+			}},
+		},
+		{
+			name: "invalid patch",
+			steps: []fakeRequestStep{createWidget, {
+				method:      http.MethodPatch,
+				path:        widgets + "/foo",
+				contentType: "application/merge-patch+json",
+				body:        `{"spec": {"size": "medium"}}`,
+				status:      http.StatusUnprocessableEntity,
+			}},
+		},
+		{
+			name: "dry run create",
+			steps: []fakeRequestStep{{
+				method: http.MethodPost,
+				path:   widgets + "?dryRun=All",
+				body:   `{"metadata": {"name": "foo"}, "spec": {"size": "small"}}`,
+				status: http.StatusCreated,
+				check: func(t *testing.T, obj *unstructured.Unstructured) {
+					expectField(t, obj, nil, "metadata", "resourceVersion")
+				},
+			}, {
+				method: http.MethodGet,
+				path:   widgets + "/foo",
+				status: http.StatusNotFound,
+			}},
+		},
+		{
+			name: "dry run patch",
+			steps: []fakeRequestStep{createWidget, {
+				method:      http.MethodPatch,
+				path:        widgets + "/foo?dryRun=All",
+				contentType: "application/merge-patch+json",
+				body:        `{"spec": {"replicas": 3}}`,
+				status:      http.StatusOK,
+				check: func(t *testing.T, obj *unstructured.Unstructured) {
+					expectField(t, obj, int64(3), "spec", "replicas")
+				},
+			}, {
+				method: http.MethodGet,
+				path:   widgets + "/foo",
+				status: http.StatusOK,
+				check: func(t *testing.T, obj *unstructured.Unstructured) {
+					expectField(t, obj, int64(1), "spec", "replicas")
+					expectField(t, obj, "1", "metadata", "resourceVersion")
+				},
+			}},
+		},
+		{
+			name: "dry run delete",
+			steps: []fakeRequestStep{createWidget, {
+				method: http.MethodDelete,
+				path:   widgets + "/foo?dryRun=All",
+				status: http.StatusOK,
+			}, {
+				method: http.MethodGet,
+				path:   widgets + "/foo",
+				status: http.StatusOK,
+			}},
+		},
+		{
+			name: "invalid object",
+			steps: []fakeRequestStep{{
+				method: http.MethodPost,
+				path:   widgets,
+				body:   `{"metadata": {"name": "foo"}, "spec": {"replicas": "one"}}`,
+				status: http.StatusUnprocessableEntity,
+			}},
+		},
+		{
+			name: "unknown field warns by default",
+			steps: []fakeRequestStep{{
+				method:  http.MethodPost,
+				path:    widgets,
+				body:    `{"metadata": {"name": "foo"}, "spec": {"size": "small", "color": "red"}}`,
+				status:  http.StatusCreated,
+				warning: `unknown field \"spec.color\"`,
+			}},
+		},
+		{
+			name: "unknown field with fieldValidation=Ignore",
+			steps: []fakeRequestStep{{
+				method: http.MethodPost,
+				path:   widgets + "?fieldValidation=Ignore",
+				body:   `{"metadata": {"name": "foo"}, "spec": {"size": "small", "color": "red"}}`,
+				status: http.StatusCreated,
+			}},
+		},
+		{
+			name: "unknown field with fieldValidation=Strict",
+			steps: []fakeRequestStep{{
+				method: http.MethodPost,
+				path:   widgets + "?fieldValidation=Strict",
+				body:   `{"metadata": {"name": "foo"}, "spec": {"size": "small", "color": "red"}}`,
+				status: http.StatusBadRequest,
+			}, {
+				method: http.MethodGet,
+				path:   widgets + "/foo",
+				status: http.StatusNotFound,
+			}},
+		},
+		{
+			name: "unknown field on update with fieldValidation=Strict",
+			steps: []fakeRequestStep{createWidget, {
+				method:      http.MethodPatch,
+				path:        widgets + "/foo?fieldValidation=Strict",
+				contentType: "application/merge-patch+json",
+				body:        `{"spec": {"color": "red"}}`,
+				status:      http.StatusBadRequest,
+			}},
+		},
+		{
+			name: "unknown resource",
+			steps: []fakeRequestStep{{
+				method: http.MethodGet,
+				path:   "/apis/example.ironcore.dev/v1alpha1/namespaces/default/doodads",
+				status: http.StatusNotFound,
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestFakeAPIServer(t)
+			for _, step := range tt.steps {
+				step.run(t, server)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// schemaValidator validates objects against a schema of an OpenAPI v3 document. It supports the subset of
+// OpenAPI used by the Kubernetes schemas: types, required properties, enums, additional properties, $ref and
+// allOf as well as the x-kubernetes-int-or-string and x-kubernetes-preserve-unknown-fields extensions.
+type schemaValidator struct {
+	doc map[string]interface{}
+
+	// unknownFields are the fields not declared in the schema found by validate.
+	unknownFields []string
+}
+
+func (v *schemaValidator) validate(path *field.Path, schema map[string]interface{}, value interface{}) field.ErrorList {
+	schema, err := v.resolve(schema)
+	if err != nil {
+		return field.ErrorList{field.InternalError(path, err)}
+	}
+	// Unset optional fields are sent as null by many clients.
+	if value == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, member := range allOf {
+			if memberSchema, ok := member.(map[string]interface{}); ok {
+				errs = append(errs, v.validate(path, memberSchema, value)...)
+			}
+		}
+	}
+
+	if intOrString, _ := schema["x-kubernetes-int-or-string"].(bool); intOrString {
+		if _, ok := value.(string); !ok && !isInteger(value) {
+			errs = append(errs, field.Invalid(path, value, "must be an integer or a string"))
+		}
+		return errs
+	}
+
+	schemaType, _ := schema["type"].(string)
+	if schemaType == "" && schema["properties"] != nil {
+		schemaType = "object"
+	}
+	switch schemaType {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return append(errs, field.Invalid(path, value, "must be of type object"))
+		}
+		errs = append(errs, v.validateObject(path, schema, obj)...)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return append(errs, field.Invalid(path, value, "must be of type array"))
+		}
+		if itemSchema, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range items {
+				errs = append(errs, v.validate(path.Index(i), itemSchema, item)...)
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return append(errs, field.Invalid(path, value, "must be of type string"))
+		}
+	case "integer":
+		if !isInteger(value) {
+			return append(errs, field.Invalid(path, value, "must be of type integer"))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok && !isInteger(value) {
+			return append(errs, field.Invalid(path, value, "must be of type number"))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return append(errs, field.Invalid(path, value, "must be of type boolean"))
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			var supported []string
+			for _, allowed := range enum {
+				supported = append(supported, fmt.Sprint(allowed))
+			}
+			errs = append(errs, field.NotSupported(path, value, supported))
+		}
+	}
+	return errs
+}
+
+func (v *schemaValidator) validateObject(path *field.Path, schema map[string]interface{}, obj map[string]interface{}) field.ErrorList {
+	var errs field.ErrorList
+	properties, _ := schema["properties"].(map[string]interface{})
+	preserveUnknown, _ := schema["x-kubernetes-preserve-unknown-fields"].(bool)
+	for key, value := range obj {
+		if propertySchema, ok := properties[key].(map[string]interface{}); ok {
+			errs = append(errs, v.validate(path.Child(key), propertySchema, value)...)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case map[string]interface{}:
+			errs = append(errs, v.validate(path.Key(key), additional, value)...)
+		case bool:
+			if !additional && !preserveUnknown {
+				v.unknownFields = append(v.unknownFields, path.Child(key).String())
+			}
+		default:
+			// Objects without any declared properties are free-form.
+			if len(properties) > 0 && !preserveUnknown {
+				v.unknownFields = append(v.unknownFields, path.Child(key).String())
+			}
+		}
+	}
+
+	required, _ := schema["required"].([]interface{})
+	for _, name := range required {
+		key, _ := name.(string)
+		if _, ok := obj[key]; !ok {
+			errs = append(errs, field.Required(path.Child(key), ""))
+		}
+	}
+	return errs
+}
+
+// resolve follows the $ref of the schema.
+func (v *schemaValidator) resolve(schema map[string]interface{}) (map[string]interface{}, error) {
+	for i := 0; ; i++ {
+		ref, ok := schema["$ref"].(string)
+		if !ok {
+			return schema, nil
+		}
+		if i > 32 {
+			return nil, fmt.Errorf("too many nested references resolving %s", ref)
+		}
+		target, err := resolveReference(v.doc, ref)
+		if err != nil {
+			return nil, err
+		}
+		if schema, ok = target.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("reference %s does not point to a schema", ref)
+		}
+	}
+}
+
+func isInteger(value interface{}) bool {
+	switch number := value.(type) {
+	case int64:
+		return true
+	case float64:
+		return number == math.Trunc(number) && !math.IsInf(number, 0)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,233 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestSchemaValidatorValidate(t *testing.T) {
+	doc := map[string]interface{}{
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Spec": map[string]interface{}{
+					"type":     "object",
+					"required": []interface{}{"size"},
+					"properties": map[string]interface{}{
+						"size": map[string]interface{}{"type": "integer"},
+					},
+				},
+				"Named": map[string]interface{}{
+					"$ref": "#/components/schemas/Spec",
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		schema        map[string]interface{}
+		value         interface{}
+		errs          []string
+		unknownFields []string
+	}{
+		{
+			name:   "matching string",
+			schema: map[string]interface{}{"type": "string"},
+			value:  "foo",
+		},
+		{
+			name:   "string mismatch",
+			schema: map[string]interface{}{"type": "string"},
+			value:  int64(1),
+			errs:   []string{"root: Invalid value"},
+		},
+		{
+			name:   "integer as float",
+			schema: map[string]interface{}{"type": "integer"},
+			value:  float64(2),
+		},
+		{
+			name:   "integer mismatch",
+			schema: map[string]interface{}{"type": "integer"},
+			value:  1.5,
+			errs:   []string{"root: Invalid value"},
+		},
+		{
+			name:   "number mismatch",
+			schema: map[string]interface{}{"type": "number"},
+			value:  "1",
+			errs:   []string{"root: Invalid value"},
+		},
+		{
+			name:   "boolean mismatch",
+			schema: map[string]interface{}{"type": "boolean"},
+			value:  "true",
+			errs:   []string{"root: Invalid value"},
+		},
+		{
+			name:   "object mismatch",
+			schema: map[string]interface{}{"type": "object"},
+			value:  []interface{}{},
+			errs:   []string{"root: Invalid value"},
+		},
+		{
+			name: "array item mismatch",
+			schema: map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string"},
+			},
+			value: []interface{}{"a", true},
+			errs:  []string{"root[1]: Invalid value"},
+		},
+		{
+			name:   "null",
+			schema: map[string]interface{}{"type": "string"},
+			value:  nil,
+		},
+		{
+			name: "missing required field",
+			schema: map[string]interface{}{
+				"type":     "object",
+				"required": []interface{}{"name"},
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{"type": "string"},
+				},
+			},
+			value: map[string]interface{}{},
+			errs:  []string{"root.name: Required value"},
+		},
+		{
+			name:   "enum",
+			schema: map[string]interface{}{"type": "string", "enum": []interface{}{"a", "b"}},
+			value:  "b",
+		},
+		{
+			name:   "enum mismatch",
+			schema: map[string]interface{}{"type": "string", "enum": []interface{}{"a", "b"}},
+			value:  "c",
+			errs:   []string{"root: Unsupported value"},
+		},
+		{
+			name: "additionalProperties schema",
+			schema: map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": map[string]interface{}{"type": "string"},
+			},
+			value: map[string]interface{}{"a": "b", "c": int64(1)},
+			errs:  []string{"root[c]: Invalid value"},
+		},
+		{
+			name: "additionalProperties false",
+			schema: map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": false,
+			},
+			value:         map[string]interface{}{"a": "b"},
+			unknownFields: []string{"root.a"},
+		},
+		{
+			name: "additionalProperties true",
+			schema: map[string]interface{}{
+				"type":                 "object",
+				"additionalProperties": true,
+			},
+			value: map[string]interface{}{"a": "b"},
+		},
+		{
+			name:   "free-form object",
+			schema: map[string]interface{}{"type": "object"},
+			value:  map[string]interface{}{"a": "b"},
+		},
+		{
+			name: "unknown fields",
+			schema: map[string]interface{}{
+				"properties": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"name": map[string]interface{}{"type": "string"},
+						},
+					},
+				},
+			},
+			value: map[string]interface{}{
+				"foo":  "bar",
+				"spec": map[string]interface{}{"name": "a", "bar": "baz"},
+			},
+			unknownFields: []string{"root.foo", "root.spec.bar"},
+		},
+		{
+			name: "preserve unknown fields",
+			schema: map[string]interface{}{
+				"type":                                 "object",
+				"x-kubernetes-preserve-unknown-fields": true,
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{"type": "string"},
+				},
+			},
+			value: map[string]interface{}{"name": "a", "foo": "bar"},
+		},
+		{
+			name:   "int-or-string integer",
+			schema: map[string]interface{}{"x-kubernetes-int-or-string": true},
+			value:  float64(80),
+		},
+		{
+			name:   "int-or-string string",
+			schema: map[string]interface{}{"x-kubernetes-int-or-string": true},
+			value:  "80%",
+		},
+		{
+			name:   "int-or-string mismatch",
+			schema: map[string]interface{}{"x-kubernetes-int-or-string": true},
+			value:  true,
+			errs:   []string{"root: Invalid value"},
+		},
+		{
+			name:   "nested reference",
+			schema: map[string]interface{}{"$ref": "#/components/schemas/Named"},
+			value:  map[string]interface{}{"size": "large"},
+			errs:   []string{"root.size: Invalid value"},
+		},
+		{
+			name: "allOf reference",
+			schema: map[string]interface{}{
+				"allOf": []interface{}{
+					map[string]interface{}{"$ref": "#/components/schemas/Spec"},
+				},
+			},
+			value: map[string]interface{}{},
+			errs:  []string{"root.size: Required value"},
+		},
+		{
+			name:   "dangling reference",
+			schema: map[string]interface{}{"$ref": "#/components/schemas/Missing"},
+			value:  "foo",
+			errs:   []string{"root: Internal error"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &schemaValidator{doc: doc}
+			errs := v.validate(field.NewPath("root"), tt.schema, tt.value)
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Field+": "+err.Type.String())
+			}
+			if !reflect.DeepEqual(got, tt.errs) {
+				t.Errorf("errors = %v, want %v", errs, tt.errs)
+			}
+
+			// Map iteration makes the order of the unknown fields random.
+			if unknownFields := slices.Sorted(slices.Values(v.unknownFields)); !reflect.DeepEqual(unknownFields, tt.unknownFields) {
+				t.Errorf("unknown fields = %v, want %v", v.unknownFields, tt.unknownFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-logr/logr"
+	"github.com/ironcore-dev/openapi-extractor/envtestutils"
+	"github.com/ironcore-dev/openapi-extractor/internal/testing/controlplane"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const (
+	kubeconfigFileName = "kubeconfig"
+)
+
+// keepEnvironmentRunning keeps the control plane and the aggregated api servers of the extraction running
+// until ctx is done, so that they can be inspected with kubectl. Nothing is kept running if the extraction
+// was interrupted.
+func keepEnvironmentRunning(
+	ctx context.Context,
+	log logr.Logger,
+	cfg *rest.Config,
+	env *envtest.Environment,
+	ext *envtestutils.EnvironmentExtensions,
+	rec *extractionRecord,
+	extractErr error,
+) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	data, err := controlplane.KubeConfigFromREST(cfg)
+	if err != nil {
+		log.Error(err, "failed to generate kubeconfig, tearing down the environment")
+		return
+	}
+	dir := filepath.Dir(rec.logDir())
+	if err := writeFile(dir, kubeconfigFileName, data); err != nil {
+		log.Error(err, "failed to write kubeconfig, tearing down the environment")
+		return
+	}
+	rec.Kubeconfig = filepath.Join(dir, kubeconfigFileName)
+
+	printConnectionInfo(os.Stderr, env, ext, rec, extractErr)
+	<-ctx.Done()
+	log.Info("Tearing down the environment")
+}
+
+func printConnectionInfo(w io.Writer, env *envtest.Environment, ext *envtestutils.EnvironmentExtensions, rec *extractionRecord, extractErr error) {
+	if extractErr != nil {
+		_, _ = fmt.Fprintf(w, "\nThe extraction failed: %v\n", extractErr)
+	} else {
+		_, _ = fmt.Fprintf(w, "\nThe extraction succeeded, its results were written and post-processed.\n")
+	}
+	_, _ = fmt.Fprintf(w, "The environment keeps running, press Ctrl-C to tear it down. Connect to it via\n\n")
+	_, _ = fmt.Fprintf(w, "\texport KUBECONFIG=%s\n\tkubectl get apiservices\n\n", rec.Kubeconfig)
+
+	if env.ControlPlane.Etcd != nil && env.ControlPlane.Etcd.URL != nil {
+		_, _ = fmt.Fprintf(w, "etcd:\n\t%s\n", env.ControlPlane.Etcd.URL)
+	}
+	_, _ = fmt.Fprintf(w, "kube-apiserver:\n\t%s\n", env.Config.Host)
+	_, _ = fmt.Fprintf(w, "Aggregated api servers:\n")
+	for _, opts := range ext.AllAPIServiceInstallOptions() {
+		_, _ = fmt.Fprintf(w, "\t%s/%s: https://%s:%d (serving certificates in %s)\n",
+			opts.ServiceNamespace, opts.ServiceName, opts.LocalServingHost, opts.LocalServingPort, opts.LocalServingCertDir)
+	}
+	_, _ = fmt.Fprintf(w, "Logs:\n\t%s\n\n", rec.logDir())
+}
@@ -12,15 +12,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"runtime"
 	"sort"
 	"time"
 
 	"github.com/go-logr/logr"
-	"github.com/ironcore-dev/controller-utils/buildutils"
 	"github.com/ironcore-dev/openapi-extractor/envtestutils"
-	"github.com/ironcore-dev/openapi-extractor/envtestutils/apiserver"
 	flag "github.com/spf13/pflag"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -43,24 +41,100 @@ var (
 	testEnvExt               *envtestutils.EnvironmentExtensions
 	log                      = ctrl.Log.WithName("openapi-extractor")
 	apiServerCommand         []string
+	apiServerArgs            []string
+	apiServerEnv             []string
+	apiServerStartAttempts   = 3
+	apiServerStartBackoff    = 1 * time.Second
+	apiServerProbe           probeConfig
 	outputDir                = "."
 	apiServicePaths          []string
+	crdPaths                 []string
+	webhookPaths             []string
 	openapiTimeout           = 30 * time.Second
 	apiServerPackage         string
 	apiServerBuildOpts       []string
+	apiServerBuild           buildConfig
 	attachControlPlaneOutput bool
 	attachAPIServerOutput    bool
+	skipValidation           bool
+	skipDiscovery            bool
+	k8sVersions              []string
+	binaryAssetsDirs         []string
+	envtestBinDir            = defaultEnvtestBinDir()
+	configFile               string
+	useBuildCache            = true
+	buildCacheDir            = defaultBuildCacheDir()
+	targetNames              []string
+	runDir                   string
+	logTailLines             = 50
+	uninstallOnStop          bool
+	verifyTeardown           bool
+	keepRunning              bool
+	watch                    bool
+	daemonSocket             string
 )
 
+// commands are the subcommands of the openapi-extractor. Without a subcommand, the OpenAPI specs are extracted.
+var commands = map[string]func(args []string) error{
+	"cache":  runCacheCommand,
+	"daemon": runDaemonCommand,
+	"serve":  runServeCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	flag.StringVar(&configFile, "config", configFile, fmt.Sprintf("Path to the config file declaring the extraction targets (default: %s if present)", defaultConfigFile))
+	flag.StringSliceVar(&targetNames, "target", targetNames, "Names of the config file targets to extract (repeatable, default: all targets)")
 	flag.StringVar(&apiServerPackage, "apiserver-package", apiServerPackage, "Package to build the api server")
-	flag.StringSliceVar(&apiServerBuildOpts, "apiserver-build-opts", apiServerBuildOpts, "Flags for building the api server")
+	flag.StringSliceVar(&apiServerBuildOpts, "apiserver-build-opts", apiServerBuildOpts, "Module download mode for building the api server")
+	_ = flag.CommandLine.MarkDeprecated("apiserver-build-opts", "use --apiserver-build-mod instead")
+	flag.StringSliceVar(&apiServerBuild.Tags, "apiserver-build-tags", apiServerBuild.Tags, "Build tags for building the api server")
+	flag.StringArrayVar(&apiServerBuild.LDFlags, "apiserver-build-ldflags", apiServerBuild.LDFlags, "Linker flags for building the api server (repeatable)")
+	flag.StringToStringVar(&apiServerBuild.VersionStamps, "apiserver-build-version-stamp", apiServerBuild.VersionStamps, "Variables to set via -ldflags -X when building the api server, e.g. k8s.io/component-base/version.gitVersion=v1.0.0")
+	flag.BoolVar(&apiServerBuild.TrimPath, "apiserver-build-trimpath", apiServerBuild.TrimPath, "Whether to build the api server with -trimpath")
+	flag.StringVar(&apiServerBuild.Mod, "apiserver-build-mod", apiServerBuild.Mod, "Module download mode for building the api server (vendor, mod or readonly)")
+	flag.StringArrayVar(&apiServerBuild.GoFlags, "apiserver-build-goflags", apiServerBuild.GoFlags, "Additional GOFLAGS for building the api server (repeatable)")
+	flag.StringVar(&apiServerBuild.Dir, "apiserver-module-dir", apiServerBuild.Dir, "Local module directory (with its own go.mod) to build --apiserver-package from")
+	flag.StringSliceVar(&apiServerBuild.Workspace, "apiserver-workspace-module", apiServerBuild.Workspace, "Local module directories to overlay via an ephemeral go.work when building the api server (repeatable)")
+	flag.StringToStringVar(&apiServerBuild.Env, "apiserver-build-env", apiServerBuild.Env, "Environment variables for building the api server, e.g. CGO_ENABLED=0,GOWORK=off")
 	flag.StringSliceVar(&apiServerCommand, "apiserver-command", apiServerCommand, "Command to run the api server")
-	flag.StringSliceVar(&apiServicePaths, "apiservices", apiServicePaths, "Comma separated list of api service definitions")
+	flag.StringArrayVar(&apiServerArgs, "apiserver-arg", apiServerArgs, "Additional api server argument as key=value (repeatable). A key without value is passed as flag without value, a key suffixed with '-' (e.g. audit-log-path-) removes the default argument")
+	flag.StringArrayVar(&apiServerEnv, "apiserver-env", apiServerEnv, "Additional environment variable of the api server process as KEY=VALUE (repeatable)")
+	flag.IntVar(&apiServerStartAttempts, "apiserver-start-attempts", apiServerStartAttempts, "Maximum number of attempts to start the api server until it becomes healthy. Each restart uses a fresh port.")
+	flag.DurationVar(&apiServerStartBackoff, "apiserver-start-backoff", apiServerStartBackoff, "Delay before restarting the api server, doubling with every further restart")
+	flag.StringArrayVar(&apiServerProbe.Paths, "apiserver-probe-path", apiServerProbe.Paths, "HTTP paths that have to respond with 200 OK for the api server to be healthy, e.g. /livez or /readyz?exclude=etcd (repeatable, default: /readyz)")
+	flag.StringSliceVar(&apiServerProbe.Exec, "apiserver-probe-exec", apiServerProbe.Exec, "Command that has to exit successfully for the api server to be healthy. It receives the api server URL via APISERVER_URL")
+	flag.BoolVar(&apiServerProbe.ClientCert, "apiserver-probe-client-cert", apiServerProbe.ClientCert, "Whether to authenticate the health probes with the client certificate of the control plane")
+	flag.BoolVar(&useBuildCache, "apiserver-build-cache", useBuildCache, "Whether to reuse previously built api server binaries if their sources did not change")
+	flag.StringVar(&buildCacheDir, "apiserver-build-cache-dir", buildCacheDir, "Directory of the api server build cache")
+	flag.StringSliceVar(&apiServicePaths, "apiservices", apiServicePaths, "Comma separated list of api service definition files or directories. Directories containing a kustomization are built and the APIServices of the output are used.")
+	flag.StringSliceVar(&crdPaths, "crds", crdPaths, "Comma separated list of CRD definition files or directories to install alongside the api server. Their group versions are extracted as well.")
+	flag.StringSliceVar(&webhookPaths, "webhooks", webhookPaths, "Comma separated list of mutating and validating webhook configuration files or directories to install alongside the api server")
 	flag.BoolVar(&attachControlPlaneOutput, "attach-control-plane-output", attachControlPlaneOutput, "Whether to print control plane output to stdout/stderr")
 	flag.BoolVar(&attachAPIServerOutput, "attach-apiserver-output", attachAPIServerOutput, "Whether to print api server output to stdout/stderr")
 	flag.StringVar(&outputDir, "output", outputDir, "Directory to store the extracted OpenAPI specs (default: current directory)")
 	flag.DurationVar(&openapiTimeout, "openapi-timeout", openapiTimeout, "Timeout to wait for the /openapi/v3 endpoint for all api services to become available")
+	flag.StringSliceVar(&k8sVersions, "k8s-version", k8sVersions, "Kubernetes control plane versions or semver constraints to extract the OpenAPI specs against (repeatable). The newest matching locally installed version is used.")
+	flag.StringSliceVar(&binaryAssetsDirs, "binary-assets-dir", binaryAssetsDirs, "Directories containing envtest control plane binaries to extract the OpenAPI specs against (repeatable)")
+	flag.StringVar(&envtestBinDir, "envtest-bin-dir", envtestBinDir, "Directory setup-envtest installed the envtest binaries into, used to look up --k8s-version")
+	flag.BoolVar(&skipDiscovery, "skip-discovery", skipDiscovery, "Whether to skip extracting the discovery documents of the api services")
+	flag.StringVar(&runDir, "run-dir", runDir, "Directory to store the logs and the summary of the run in (default: a new temporary directory)")
+	flag.IntVar(&logTailLines, "log-tail-lines", logTailLines, "Number of last log lines of each component to print if an extraction fails")
+	flag.BoolVar(&uninstallOnStop, "uninstall-on-stop", uninstallOnStop, "Whether to delete the APIServices and services from the control plane when stopping, e.g. when using an existing cluster")
+	flag.BoolVar(&verifyTeardown, "verify-teardown", verifyTeardown, "Whether to fail if temporary directories, port lock files or processes are left behind after stopping")
+	flag.BoolVar(&keepRunning, "keep-running", keepRunning, "Whether to keep the control plane and the api servers running after the extraction until interrupted, e.g. to inspect them with kubectl. Requires a single target and control plane.")
+	flag.BoolVar(&watch, "watch", watch, "Whether to keep the control plane running and extract again whenever the sources of --apiserver-package or the APIService definitions change. Requires a single target and control plane.")
+	flag.StringVar(&daemonSocket, "daemon-socket", daemonSocket, fmt.Sprintf("Unix socket of an openapi-extractor daemon to extract with instead of starting a control plane, e.g. %s", defaultDaemonSocket()))
+	flag.BoolVar(&skipValidation, "skip-validation", skipValidation, "Whether to skip validating the extracted OpenAPI documents before writing them")
 
 	opts := zap.Options{
 		Development: true,
@@ -70,96 +144,367 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	targets, err := loadTargets(flag.CommandLine)
+	if err != nil {
+		log.Error(err, "failed to load targets")
+		os.Exit(1)
+	}
+	if err := validateRunMode(targets); err != nil {
+		log.Error(err, "invalid flags")
+		os.Exit(1)
+	}
+
+	generatedRunDir, err := setupRunDir()
+	if err != nil {
+		log.Error(err, "failed to setup run directory")
+		os.Exit(1)
+	}
+	log.Info("Using run directory", "RunDirectory", runDir)
+	pruneBuildCache()
+
+	summary := &runSummary{Started: time.Now()}
 	ctx, cancel := context.WithCancel(ctrl.SetupSignalHandler())
-	if err := extractOpenAPI(ctx); err != nil {
-		cancel()
+	err = run(ctx, targets, summary)
+	cancel()
+
+	summary.Finished = time.Now()
+	summary.Succeeded = err == nil
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	if err := summary.write(); err != nil {
+		log.Error(err, "failed to write run summary")
+	}
+	cleanupRunDir(generatedRunDir, err)
+
+	if err != nil {
 		log.Error(err, "failed to extract OpenAPI")
 		os.Exit(1)
 	}
 }
 
-func extractOpenAPI(ctx context.Context) error {
-	testEnv = &envtest.Environment{
-		AttachControlPlaneOutput: attachControlPlaneOutput,
+// extractionResult contains everything extracted from a single control plane.
+type extractionResult struct {
+	v2        []byte
+	v3        map[schema.GroupVersion][]byte
+	discovery *discoveryDocuments
+	servers   []serverResult
+}
+
+func (r *extractionResult) write(outputDir string, layout *outputConfig) error {
+	if err := writeOpenAPI(outputDir, layout, r.v2, r.v3); err != nil {
+		return fmt.Errorf("failed to write OpenAPI specs: %w", err)
+	}
+
+	if r.discovery != nil {
+		if err := writeDiscovery(filepath.Join(outputDir, layout.DiscoveryDir), r.discovery); err != nil {
+			return fmt.Errorf("failed to write discovery: %w", err)
+		}
+
+		if err := writeResourceReport(outputDir, buildResourceReport(r.discovery)); err != nil {
+			return fmt.Errorf("failed to write resource report: %w", err)
+		}
+	}
+
+	for i := range r.servers {
+		if err := r.servers[i].write(outputDir, layout, r.v3); err != nil {
+			return fmt.Errorf("failed to write OpenAPI specs of server %s: %w", r.servers[i].name, err)
+		}
+	}
+	return nil
+}
+
+func run(ctx context.Context, targets []target, summary *runSummary) error {
+	for i := range targets {
+		t := &targets[i]
+		targetLog := log
+		if len(targets) > 1 {
+			targetLog = log.WithValues("Target", t.Name)
+		}
+
+		if err := runTarget(ctx, targetLog, t, summary); err != nil {
+			return fmt.Errorf("target %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+func runTarget(ctx context.Context, log logr.Logger, t *target, summary *runSummary) error {
+	resolver := &binaryAssetsResolver{
+		KubebuilderAssets: os.Getenv(envKubebuilderAssets),
+		BinDir:            envtestBinDir,
+	}
+	var planes []controlPlane
+	if daemonSocket != "" {
+		// The daemon extracts against the control plane it runs.
+		planes = []controlPlane{{Name: daemonControlPlaneName}}
+	} else {
+		var err error
+		planes, err = controlPlanes(resolver, t.ControlPlane.K8sVersions, t.ControlPlane.BinaryAssetsDirs)
+		if err != nil {
+			return fmt.Errorf("failed to determine control planes: %w", err)
+		}
+	}
+	if (keepRunning || watch) && len(planes) > 1 {
+		return fmt.Errorf("--keep-running and --watch require a single control plane, got %d", len(planes))
+	}
+
+	results := make([]*extractionResult, 0, len(planes))
+	for _, plane := range planes {
+		planeLog := log
+		if len(planes) > 1 {
+			planeLog = log.WithValues("ControlPlane", plane.Name)
+		}
+
+		rec := summary.newExtraction(t.Name, plane)
+		res, err := extractOpenAPI(ctx, planeLog, t, plane, rec)
+		if err != nil {
+			rec.Error = err.Error()
+			return fmt.Errorf("failed to extract OpenAPI from control plane %s: %w", plane.Name, err)
+		}
+		rec.Succeeded = true
+		results = append(results, res)
+	}
+	if watch || keepRunning {
+		// Every watch cycle respectively the extraction before keeping the environment running already wrote
+		// and post-processed its outputs.
+		return nil
+	}
+
+	// Only write once all extractions succeeded to not leave a partially updated output behind.
+	for i, plane := range planes {
+		dir := t.Output.Dir
+		if len(planes) > 1 {
+			dir = filepath.Join(t.Output.Dir, plane.Name)
+		}
+
+		if err := results[i].write(dir, &t.Output); err != nil {
+			return fmt.Errorf("failed to write results of control plane %s: %w", plane.Name, err)
+		}
+	}
+
+	if len(planes) > 1 {
+		if err := writeVersionDiffReport(t.Output.Dir, planes, results); err != nil {
+			return fmt.Errorf("failed to write version diff report: %w", err)
+		}
+	}
 
-		// The BinaryAssetsDirectory is only required if you want to run the tests directly
-		// without call the makefile target test. If not informed it will look for the
-		// default path defined in controller-runtime which is /usr/local/kubebuilder/.
-		// Note that you must have the required binaries setup under the bin directory to perform
-		// the tests directly. When we run make test it will be setup and used automatically.
-		BinaryAssetsDirectory: filepath.Join("..", "..", "bin", "k8s",
-			fmt.Sprintf("1.31.0-%s-%s", runtime.GOOS, runtime.GOARCH)),
+	if err := runPostProcess(ctx, log, t); err != nil {
+		return fmt.Errorf("failed to post-process outputs: %w", err)
 	}
-	testEnvExt = &envtestutils.EnvironmentExtensions{
-		APIServiceDirectoryPaths:       apiServicePaths,
-		ErrorIfAPIServicePathIsMissing: true,
+	return nil
+}
+
+// writeOutputs writes the result of a target extracted against a single control plane and post-processes it.
+func writeOutputs(ctx context.Context, log logr.Logger, t *target, res *extractionResult) error {
+	if err := res.write(t.Output.Dir, &t.Output); err != nil {
+		return fmt.Errorf("failed to write results: %w", err)
+	}
+	if err := runPostProcess(ctx, log, t); err != nil {
+		return fmt.Errorf("failed to post-process outputs: %w", err)
+	}
+	return nil
+}
+
+// validateRunMode validates the flags keeping the environment running.
+func validateRunMode(targets []target) error {
+	if keepRunning && watch {
+		return fmt.Errorf("must not specify --keep-running and --watch simultaneously")
+	}
+	if (keepRunning || watch) && daemonSocket != "" {
+		return fmt.Errorf("--keep-running and --watch cannot be used with --daemon-socket")
+	}
+	if (keepRunning || watch) && len(targets) > 1 {
+		return fmt.Errorf("--keep-running and --watch require a single target, got %d", len(targets))
+	}
+	return nil
+}
+
+func extractOpenAPI(ctx context.Context, log logr.Logger, t *target, plane controlPlane, rec *extractionRecord) (_ *extractionResult, retErr error) {
+	if daemonSocket != "" {
+		return extractWithDaemon(ctx, log, t, rec)
+	}
+
+	// Registered first to run after all processes were stopped and their logs are complete.
+	defer func() {
+		if retErr != nil {
+			rec.dumpLogs(os.Stderr, logTailLines)
+		}
+	}()
+
+	testEnv = &envtest.Environment{
+		AttachControlPlaneOutput: attachControlPlaneOutput,
+		BinaryAssetsDirectory:    plane.BinaryAssetsDirectory,
+		CRDInstallOptions: envtest.CRDInstallOptions{
+			Paths:              t.CRDs,
+			ErrorIfPathMissing: true,
+			CleanUpAfterUse:    uninstallOnStop,
+		},
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			Paths: t.Webhooks,
+		},
 	}
+	servers := t.servers()
+	testEnvExt = environmentExtensions(servers)
+	testEnvExt.LogDir = rec.logDir()
+	testEnvExt.LogOptions = logOptions()
+	testEnvExt.UninstallOnStop = uninstallOnStop
 
 	cfg, err := envtestutils.StartWithExtensions(testEnv, testEnvExt)
+	rec.addLogFiles(testEnvExt.LogFiles()...)
 	if err != nil {
-		return fmt.Errorf("failed to start testenv: %w", err)
+		return nil, fmt.Errorf("failed to start testenv: %w", err)
 	}
 	defer func() {
 		if err := envtestutils.StopWithExtensions(testEnv, testEnvExt); err != nil {
 			log.Error(err, "failed to stop testenv")
 		}
+		if verifyTeardown {
+			if err := envtestutils.VerifyStopped(testEnvExt); err != nil && retErr == nil {
+				retErr = fmt.Errorf("failed to verify teardown: %w", err)
+			}
+		}
 	}()
 
+	var stopAPIServers func() error
+	defer func() {
+		if stopAPIServers == nil {
+			return
+		}
+		if err := stopAPIServers(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("failed to verify teardown: %w", err)
+		}
+	}()
+	if keepRunning {
+		// Registered before starting the api servers to keep the control plane running if they fail to start.
+		defer func() {
+			keepEnvironmentRunning(ctx, log, cfg, testEnv, testEnvExt, rec, retErr)
+		}()
+	}
+
 	k8sClient, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
 	if err != nil {
-		return fmt.Errorf("failed to create Kubernetes client: %w", err)
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
 	}
 
-	var buildOpts []buildutils.BuildOption
-	for _, buildOpt := range apiServerBuildOpts {
-		buildOpts = append(buildOpts, buildutils.ModMode(buildOpt)) // TODO: This is not correct. Fix this.
+	apiServers, stop, err := startAPIServers(ctx, log, cfg, testEnv, testEnvExt, servers, rec)
+	if err != nil {
+		return nil, err
 	}
+	stopAPIServers = stop
 
-	apiSrv, err := apiserver.New(cfg, apiserver.Options{
-		AttachOutput: attachAPIServerOutput,
-		Command:      apiServerCommand,
-		MainPath:     apiServerPackage,
-		BuildOptions: buildOpts,
-		ETCDServers:  []string{testEnv.ControlPlane.Etcd.URL.String()},
-		Host:         testEnvExt.APIServiceInstallOptions.LocalServingHost,
-		Port:         testEnvExt.APIServiceInstallOptions.LocalServingPort,
-		CertDir:      testEnvExt.APIServiceInstallOptions.LocalServingCertDir,
-	})
+	clientSet, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to setup api server: %w", err)
+		return nil, fmt.Errorf("failed to create clientset from config: %w", err)
 	}
 
-	if err := apiSrv.Start(); err != nil {
-		return fmt.Errorf("failed to start api server: %w", err)
+	res, err := extract(ctx, log, t, servers, testEnvExt, k8sClient, clientSet, rec)
+	if keepRunning && err == nil {
+		// The outputs are written while the environment is running to compare them against it.
+		if err := writeOutputs(ctx, log, t, res); err != nil {
+			return nil, err
+		}
 	}
-	defer func() {
-		if err := apiSrv.Stop(); err != nil {
-			log.Error(err, "failed to stop api server")
+	if watch {
+		w := &targetWatcher{
+			log:        log,
+			target:     t,
+			cfg:        cfg,
+			ext:        testEnvExt,
+			servers:    servers,
+			apiServers: apiServers,
+			client:     k8sClient,
+			clientSet:  clientSet,
+			rec:        rec,
+			out:        os.Stdout,
 		}
-	}()
+		return w.run(ctx, res, err)
+	}
+	return res, err
+}
 
-	if err := envtestutils.WaitUntilAPIServicesReadyWithTimeout(apiServiceTimeout, testEnvExt, k8sClient, scheme.Scheme); err != nil {
-		return fmt.Errorf("failed to wait for api server to become ready: %w", err)
+// extract extracts the OpenAPI specs and discovery documents of the running aggregated api servers.
+func extract(
+	ctx context.Context,
+	log logr.Logger,
+	t *target,
+	servers []serverConfig,
+	ext *envtestutils.EnvironmentExtensions,
+	k8sClient client.Client,
+	clientSet *kubernetes.Clientset,
+	rec *extractionRecord,
+) (*extractionResult, error) {
+	waitCtx, cancelWait := context.WithTimeout(ctx, apiServiceTimeout)
+	defer cancelWait()
+	if err := envtestutils.WaitUntilAPIServicesReady(waitCtx, ext, k8sClient, scheme.Scheme); err != nil {
+		err = fmt.Errorf("failed to wait for api server to become ready: %w", err)
+		collectDiagnostics(ctx, log, k8sClient, clientSet, ext, apiServiceGroupVersions(ext.AllAPIServices()), rec, err)
+		return nil, err
 	}
 
-	clientSet, err := kubernetes.NewForConfig(cfg)
+	if err := envtestutils.WaitUntilCRDsEstablished(waitCtx, k8sClient, ext.CRDs()...); err != nil {
+		err = fmt.Errorf("failed to wait for crds to become established: %w", err)
+		collectDiagnostics(ctx, log, k8sClient, clientSet, ext, crdGroupVersions(ext.CRDs()), rec, err)
+		return nil, err
+	}
+
+	// The OpenAPI v3 documents of CRDs are published shortly after they become established.
+	openAPIGVs := append(apiServiceGroupVersions(ext.AllAPIServices()), crdGroupVersions(ext.CRDs())...)
+	if err := waitForOpenAPIV3(ctx, log, clientSet, openapiTimeout, openAPIGVs); err != nil {
+		err = fmt.Errorf("failed to wait for the api services to become available: %w", err)
+		collectDiagnostics(ctx, log, k8sClient, clientSet, ext, openAPIGVs, rec, err)
+		return nil, err
+	}
+
+	v2, err := extractOpenAPIv2(ctx, log, clientSet)
 	if err != nil {
-		return fmt.Errorf("failed to create clientset from config: %w", err)
+		return nil, fmt.Errorf("failed to extract OpenAPI v2 spec: %w", err)
 	}
 
-	if err := waitForAPIServicesOpenAPIV3(ctx, log, clientSet, openapiTimeout, testEnvExt.APIServiceInstallOptions.APIServices); err != nil {
-		return fmt.Errorf("failed to wait for the api services to become available: %w", err)
+	gvs := t.Filters.groupVersions(ext.AllAPIServices(), ext.CRDs()...)
+
+	v3, err := extractOpenAPIv3(ctx, log, clientSet, gvs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract OpenAPI v3 spec: %w", err)
 	}
 
-	if err := extractOpenAPIv2(ctx, log, clientSet, outputDir); err != nil {
-		return fmt.Errorf("failed to extract OpenAPI v2 spec: %w", err)
+	var discoveryDocs *discoveryDocuments
+	if !t.SkipDiscovery {
+		discoveryDocs, err = extractDiscovery(ctx, log, clientSet, gvs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract discovery: %w", err)
+		}
 	}
 
-	if err := extractOpenAPIv3(ctx, log, clientSet, testEnvExt, outputDir); err != nil {
-		return fmt.Errorf("failed to extract OpenAPI v3 spec: %w", err)
+	if !t.SkipValidation {
+		if err := validateOpenAPI(log, v2, v3); err != nil {
+			return nil, fmt.Errorf("failed to validate OpenAPI specs: %w", err)
+		}
 	}
 
-	return nil
+	var serverResults []serverResult
+	if len(servers) > 1 {
+		for i, installOpts := range ext.AllAPIServiceInstallOptions() {
+			serverGVs := t.Filters.groupVersions(installOpts.APIServices)
+			serverV2, err := filterOpenAPIv2(v2, serverGVs)
+			if err != nil {
+				return nil, fmt.Errorf("failed to filter OpenAPI v2 spec of server %s: %w", servers[i].Name, err)
+			}
+
+			serverResults = append(serverResults, serverResult{
+				name: servers[i].Name,
+				v2:   serverV2,
+				gvs:  serverGVs,
+			})
+		}
+	}
+
+	return &extractionResult{
+		v2:        v2,
+		v3:        v3,
+		discovery: discoveryDocs,
+		servers:   serverResults,
+	}, nil
 }
 
 func sortedGroupVersions(gvs []schema.GroupVersion) []schema.GroupVersion {
@@ -169,20 +514,41 @@ func sortedGroupVersions(gvs []schema.GroupVersion) []schema.GroupVersion {
 	return gvs
 }
 
-func waitForAPIServicesOpenAPIV3(
-	ctx context.Context,
-	log logr.Logger,
-	clientSet *kubernetes.Clientset,
-	timeout time.Duration,
-	services []*apiregistrationv1.APIService,
-) error {
-	testGVs := sets.New[schema.GroupVersion]()
+func apiServiceGroupVersions(services []*apiregistrationv1.APIService) []schema.GroupVersion {
+	gvs := sets.New[schema.GroupVersion]()
 	for _, svc := range services {
-		testGVs.Insert(schema.GroupVersion{
+		gvs.Insert(schema.GroupVersion{
 			Group:   svc.Spec.Group,
 			Version: svc.Spec.Version,
 		})
 	}
+	return sortedGroupVersions(gvs.UnsortedList())
+}
+
+// crdGroupVersions returns the group versions served by the given CRDs.
+func crdGroupVersions(crds []*apiextensionsv1.CustomResourceDefinition) []schema.GroupVersion {
+	gvs := sets.New[schema.GroupVersion]()
+	for _, crd := range crds {
+		for _, version := range crd.Spec.Versions {
+			if version.Served {
+				gvs.Insert(schema.GroupVersion{
+					Group:   crd.Spec.Group,
+					Version: version.Name,
+				})
+			}
+		}
+	}
+	return sortedGroupVersions(gvs.UnsortedList())
+}
+
+func waitForOpenAPIV3(
+	ctx context.Context,
+	log logr.Logger,
+	clientSet *kubernetes.Clientset,
+	timeout time.Duration,
+	gvs []schema.GroupVersion,
+) error {
+	testGVs := sets.New(gvs...)
 
 	if err := wait.PollUntilContextTimeout(ctx, 1*time.Second, timeout, true, func(ctx context.Context) (done bool, err error) {
 		newTestGVs := sets.New[schema.GroupVersion]()
@@ -211,56 +577,90 @@ func waitForAPIServicesOpenAPIV3(
 	return nil
 }
 
-func extractOpenAPIv3(ctx context.Context, log logr.Logger, clientSet *kubernetes.Clientset, ext *envtestutils.EnvironmentExtensions, outputDir string) error {
+func extractOpenAPIv3(ctx context.Context, log logr.Logger, clientSet *kubernetes.Clientset, gvs []schema.GroupVersion) (map[schema.GroupVersion][]byte, error) {
 	log.Info("Extracting OpenAPI v3")
-	apiServices := ext.APIServiceInstallOptions.APIServices
 
-	for _, apiService := range apiServices {
-		fileName := fmt.Sprintf("apis__%s__%s_openapi.json", apiService.Spec.Group, apiService.Spec.Version)
-		path := fmt.Sprintf("/openapi/v3/apis/%s/%s", apiService.Spec.Group, apiService.Spec.Version)
+	res := make(map[schema.GroupVersion][]byte, len(gvs))
+	for _, gv := range gvs {
+		path := fmt.Sprintf("/openapi/v3/apis/%s/%s", gv.Group, gv.Version)
 
 		resp, err := getPath(ctx, clientSet, path)
 		if err != nil {
-			return fmt.Errorf("failed to get OpenAPI v3 path %s: %w", path, err)
+			return nil, fmt.Errorf("failed to get OpenAPI v3 path %s: %w", path, err)
 		}
 
-		if err := writeJSONFile(fmt.Sprintf("%s/%s", outputDir, "v3"), fileName, resp); err != nil {
-			return fmt.Errorf("failed to write OpenAPI v3 file: %w", err)
-		}
+		res[gv] = resp
 	}
-	return nil
+	return res, nil
 }
 
-func extractOpenAPIv2(ctx context.Context, log logr.Logger, clientSet *kubernetes.Clientset, outputDir string) error {
+func extractOpenAPIv2(ctx context.Context, log logr.Logger, clientSet *kubernetes.Clientset) ([]byte, error) {
 	log.Info("Extracting OpenAPI v2")
 
 	path := "/openapi/v2"
 	resp, err := getPath(ctx, clientSet, path)
 	if err != nil {
-		return fmt.Errorf("failed to get OpenAPI v3 path %s: %w", path, err)
+		return nil, fmt.Errorf("failed to get OpenAPI v2 path %s: %w", path, err)
 	}
+	return resp, nil
+}
 
-	if err := writeJSONFile(outputDir, "swagger.json", resp); err != nil {
-		return fmt.Errorf("failed to write OpenAPI v2 file: %w", err)
+func validateOpenAPI(log logr.Logger, v2 []byte, v3 map[schema.GroupVersion][]byte) error {
+	log.Info("Validating OpenAPI specs")
+
+	gvs := make([]schema.GroupVersion, 0, len(v3))
+	for gv := range v3 {
+		gvs = append(gvs, gv)
+	}
+	gvs = sortedGroupVersions(gvs)
+
+	if err := validateOpenAPIv2(v2, gvs); err != nil {
+		return fmt.Errorf("invalid OpenAPI v2 spec: %w", err)
 	}
 
+	for _, gv := range gvs {
+		if err := validateOpenAPIv3(v3[gv], gv); err != nil {
+			return fmt.Errorf("invalid OpenAPI v3 spec for %s: %w", gv, err)
+		}
+	}
 	return nil
 }
 
-func writeJSONFile(dir string, name string, jsonData []byte) error {
-	log.Info("Writing file", "OutputDirectory", dir, "File", name)
+func openAPIv3FileName(gv schema.GroupVersion) string {
+	return fmt.Sprintf("apis__%s__%s_openapi.json", gv.Group, gv.Version)
+}
 
-	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create output directory %s: %w", dir, err)
+func writeOpenAPI(outputDir string, layout *outputConfig, v2 []byte, v3 map[schema.GroupVersion][]byte) error {
+	if err := writeJSONFile(filepath.Join(outputDir, filepath.Dir(layout.V2File)), layout.V2File, v2); err != nil {
+		return fmt.Errorf("failed to write OpenAPI v2 file: %w", err)
 	}
 
+	for gv, data := range v3 {
+		if err := writeJSONFile(filepath.Join(outputDir, layout.V3Dir), openAPIv3FileName(gv), data); err != nil {
+			return fmt.Errorf("failed to write OpenAPI v3 file: %w", err)
+		}
+	}
+	return nil
+}
+
+func writeJSONFile(dir string, name string, jsonData []byte) error {
 	var out bytes.Buffer
 	if err := json.Indent(&out, jsonData, "", "\t"); err != nil {
 		return fmt.Errorf("failed to pretty print JSON: %w", err)
 	}
 
+	return writeFile(dir, name, out.Bytes())
+}
+
+func writeFile(dir string, name string, data []byte) error {
+	log.Info("Writing file", "OutputDirectory", dir, "File", name)
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", dir, err)
+	}
+
 	filename := filepath.Join(dir, filepath.Base(name))
-	if err := os.WriteFile(filename, out.Bytes(), 0600); err != nil {
+	if err := os.WriteFile(filename, data, 0600); err != nil {
 		return fmt.Errorf("error writing file %s: %w", filename, err)
 	}
 	return nil
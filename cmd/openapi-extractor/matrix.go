@@ -0,0 +1,292 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// controlPlane is a Kubernetes control plane the OpenAPI specs are extracted against.
+type controlPlane struct {
+	// Name identifies the control plane, e.g. in the output directory structure.
+	Name string
+	// BinaryAssetsDirectory is the directory containing the envtest binaries of the control plane.
+	BinaryAssetsDirectory string
+}
+
+// controlPlaneNameForDirectory derives the control plane name from an envtest binary assets directory,
+// stripping the platform suffix used by setup-envtest (e.g. 1.31.0-linux-amd64 becomes 1.31.0).
+func controlPlaneNameForDirectory(dir string) string {
+	return strings.TrimSuffix(filepath.Base(filepath.Clean(dir)), fmt.Sprintf("-%s-%s", runtime.GOOS, runtime.GOARCH))
+}
+
+// controlPlanes determines the control planes to extract the OpenAPI specs against.
+// Versions are semver constraints resolved against the locally available envtest binaries,
+// directories are used as-is.
+func controlPlanes(resolver *binaryAssetsResolver, versions, dirs []string) ([]controlPlane, error) {
+	var planes []controlPlane
+	for _, version := range versions {
+		assets, err := resolver.Resolve(version)
+		if err != nil {
+			return nil, err
+		}
+
+		name := controlPlaneNameForDirectory(assets.Directory)
+		if assets.Version != nil {
+			name = assets.Version.String()
+		}
+		planes = append(planes, controlPlane{
+			Name:                  name,
+			BinaryAssetsDirectory: assets.Directory,
+		})
+	}
+	for _, dir := range dirs {
+		if !hasRequiredBinaries(dir) {
+			return nil, fmt.Errorf("binary assets directory %s does not contain all of %v", dir, requiredBinaries)
+		}
+		planes = append(planes, controlPlane{
+			Name:                  controlPlaneNameForDirectory(dir),
+			BinaryAssetsDirectory: dir,
+		})
+	}
+	if len(planes) == 0 {
+		assets, err := resolver.Resolve("")
+		if err != nil {
+			return nil, err
+		}
+		planes = append(planes, controlPlane{
+			Name:                  controlPlaneNameForDirectory(assets.Directory),
+			BinaryAssetsDirectory: assets.Directory,
+		})
+	}
+
+	names := sets.New[string]()
+	for _, plane := range planes {
+		if names.Has(plane.Name) {
+			return nil, fmt.Errorf("duplicate control plane %s", plane.Name)
+		}
+		names.Insert(plane.Name)
+	}
+	return planes, nil
+}
+
+// versionDiffReport describes how the served specs differ between consecutive control planes.
+type versionDiffReport struct {
+	Diffs []versionDiff `json:"diffs"`
+}
+
+type versionDiff struct {
+	From string     `json:"from"`
+	To   string     `json:"to"`
+	V2   *specDiff  `json:"v2,omitempty"`
+	V3   []specDiff `json:"v3,omitempty"`
+}
+
+type specDiff struct {
+	// GroupVersion is the group version of an OpenAPI v3 document. It is empty for OpenAPI v2.
+	GroupVersion string `json:"groupVersion,omitempty"`
+
+	AddedPaths     []string `json:"addedPaths,omitempty"`
+	RemovedPaths   []string `json:"removedPaths,omitempty"`
+	ChangedPaths   []string `json:"changedPaths,omitempty"`
+	AddedSchemas   []string `json:"addedSchemas,omitempty"`
+	RemovedSchemas []string `json:"removedSchemas,omitempty"`
+	ChangedSchemas []string `json:"changedSchemas,omitempty"`
+}
+
+func (d *specDiff) empty() bool {
+	return len(d.AddedPaths) == 0 && len(d.RemovedPaths) == 0 && len(d.ChangedPaths) == 0 &&
+		len(d.AddedSchemas) == 0 && len(d.RemovedSchemas) == 0 && len(d.ChangedSchemas) == 0
+}
+
+func buildVersionDiffReport(planes []controlPlane, results []*extractionResult) (*versionDiffReport, error) {
+	report := &versionDiffReport{}
+	for i := 1; i < len(results); i++ {
+		diff, err := diffResults(results[i-1], results[i])
+		if err != nil {
+			return nil, err
+		}
+		diff.From = planes[i-1].Name
+		diff.To = planes[i].Name
+		report.Diffs = append(report.Diffs, *diff)
+	}
+	return report, nil
+}
+
+// diffResults compares the OpenAPI v2 and v3 specs of two extractions.
+func diffResults(from, to *extractionResult) (*versionDiff, error) {
+	diff := &versionDiff{}
+	v2Diff, err := diffSpecs(from.v2, to.v2, []string{"definitions"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff OpenAPI v2 specs: %w", err)
+	}
+	if !v2Diff.empty() {
+		diff.V2 = v2Diff
+	}
+
+	gvs := sets.New[schema.GroupVersion]()
+	for gv := range from.v3 {
+		gvs.Insert(gv)
+	}
+	for gv := range to.v3 {
+		gvs.Insert(gv)
+	}
+	for _, gv := range sortedGroupVersions(gvs.UnsortedList()) {
+		v3Diff, err := diffSpecs(from.v3[gv], to.v3[gv], []string{"components", "schemas"})
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff OpenAPI v3 specs of %s: %w", gv, err)
+		}
+		if !v3Diff.empty() {
+			v3Diff.GroupVersion = gv.String()
+			diff.V3 = append(diff.V3, *v3Diff)
+		}
+	}
+	return diff, nil
+}
+
+// diffSpecs compares the paths and the schemas found at schemasPath of two OpenAPI documents.
+// A missing document is treated like a document without any paths and schemas.
+func diffSpecs(from, to []byte, schemasPath []string) (*specDiff, error) {
+	fromDoc, err := parseSpecDocument(from)
+	if err != nil {
+		return nil, err
+	}
+	toDoc, err := parseSpecDocument(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &specDiff{}
+	diff.AddedPaths, diff.RemovedPaths, diff.ChangedPaths = diffObjects(
+		lookupObject(fromDoc, "paths"),
+		lookupObject(toDoc, "paths"),
+	)
+	diff.AddedSchemas, diff.RemovedSchemas, diff.ChangedSchemas = diffObjects(
+		lookupObject(fromDoc, schemasPath...),
+		lookupObject(toDoc, schemasPath...),
+	)
+	return diff, nil
+}
+
+func parseSpecDocument(data []byte) (map[string]interface{}, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse document: %w", err)
+	}
+	return doc, nil
+}
+
+func lookupObject(doc map[string]interface{}, path ...string) map[string]interface{} {
+	cur := doc
+	for _, key := range path {
+		next, ok := cur[key].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = next
+	}
+	return cur
+}
+
+func diffObjects(from, to map[string]interface{}) (added, removed, changed []string) {
+	for key, toValue := range to {
+		fromValue, ok := from[key]
+		switch {
+		case !ok:
+			added = append(added, key)
+		case !reflect.DeepEqual(fromValue, toValue):
+			changed = append(changed, key)
+		}
+	}
+	for key := range from {
+		if _, ok := to[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+func (r *versionDiffReport) markdown() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# OpenAPI Differences Between Control Plane Versions\n")
+
+	for _, diff := range r.Diffs {
+		fmt.Fprintf(&buf, "\n## %s → %s\n", diff.From, diff.To)
+		if diff.V2 == nil && len(diff.V3) == 0 {
+			buf.WriteString("\nNo differences.\n")
+			continue
+		}
+
+		if diff.V2 != nil {
+			buf.WriteString("\n### OpenAPI v2\n\n")
+			diff.V2.writeMarkdown(&buf, "definitions")
+		}
+		for _, v3Diff := range diff.V3 {
+			fmt.Fprintf(&buf, "\n### OpenAPI v3 %s\n\n", v3Diff.GroupVersion)
+			v3Diff.writeMarkdown(&buf, "schemas")
+		}
+	}
+	return buf.Bytes()
+}
+
+func (d *specDiff) writeMarkdown(buf *bytes.Buffer, schemas string) {
+	for _, section := range []struct {
+		title string
+		items []string
+	}{
+		{"Added paths", d.AddedPaths},
+		{"Removed paths", d.RemovedPaths},
+		{"Changed paths", d.ChangedPaths},
+		{"Added " + schemas, d.AddedSchemas},
+		{"Removed " + schemas, d.RemovedSchemas},
+		{"Changed " + schemas, d.ChangedSchemas},
+	} {
+		if len(section.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(buf, "* %s:\n", section.title)
+		for _, item := range section.items {
+			fmt.Fprintf(buf, "  * `%s`\n", item)
+		}
+	}
+}
+
+// summary describes the numbers of added, removed and changed paths and schemas in a single line.
+func (d *specDiff) summary(schemas string) string {
+	return fmt.Sprintf("+%d -%d ~%d paths, +%d -%d ~%d %s",
+		len(d.AddedPaths), len(d.RemovedPaths), len(d.ChangedPaths),
+		len(d.AddedSchemas), len(d.RemovedSchemas), len(d.ChangedSchemas), schemas)
+}
+
+func writeVersionDiffReport(outputDir string, planes []controlPlane, results []*extractionResult) error {
+	report, err := buildVersionDiffReport(planes, results)
+	if err != nil {
+		return err
+	}
+
+	if err := writeJSONObject(outputDir, "version-diff.json", report); err != nil {
+		return fmt.Errorf("failed to write version diff report: %w", err)
+	}
+
+	if err := writeFile(outputDir, "version-diff.md", report.markdown()); err != nil {
+		return fmt.Errorf("failed to write version diff report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	envOutputDir = "OPENAPI_EXTRACTOR_OUTPUT_DIR"
+)
+
+// runPostProcess runs the post-processing commands of the target in its output directory.
+func runPostProcess(ctx context.Context, log logr.Logger, t *target) error {
+	if len(t.PostProcess) == 0 {
+		return nil
+	}
+
+	dir, err := filepath.Abs(t.Output.Dir)
+	if err != nil {
+		return fmt.Errorf("error determining absolute output directory: %w", err)
+	}
+
+	for i, step := range t.PostProcess {
+		if len(step.Command) == 0 {
+			return fmt.Errorf("post-process step %d: must specify command", i)
+		}
+
+		log.Info("Running post-process command", "Command", step.Command)
+		cmd := exec.CommandContext(ctx, step.Command[0], step.Command[1:]...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", envOutputDir, dir))
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("post-process step %d (%v) failed: %w", i, step.Command, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// resourceReport is the inventory of all resources served by the extracted group versions.
+type resourceReport struct {
+	Resources []resourceReportEntry `json:"resources"`
+}
+
+type resourceReportEntry struct {
+	Group        string                   `json:"group"`
+	Version      string                   `json:"version"`
+	Kind         string                   `json:"kind"`
+	Resource     string                   `json:"resource"`
+	SingularName string                   `json:"singularName,omitempty"`
+	Namespaced   bool                     `json:"namespaced"`
+	Verbs        []string                 `json:"verbs,omitempty"`
+	Subresources []subresourceReportEntry `json:"subresources,omitempty"`
+	ShortNames   []string                 `json:"shortNames,omitempty"`
+	Categories   []string                 `json:"categories,omitempty"`
+
+	// PreferredVersion reports whether Version is the preferred version of the group.
+	PreferredVersion bool `json:"preferredVersion"`
+	// StorageVersionHash is the hash of the version the resource is persisted in.
+	// Resources of different versions sharing the same hash are stored in the same version.
+	StorageVersionHash string `json:"storageVersionHash,omitempty"`
+}
+
+type subresourceReportEntry struct {
+	Name  string   `json:"name"`
+	Kind  string   `json:"kind,omitempty"`
+	Verbs []string `json:"verbs,omitempty"`
+}
+
+func (e *resourceReportEntry) groupVersion() schema.GroupVersion {
+	return schema.GroupVersion{Group: e.Group, Version: e.Version}
+}
+
+// buildResourceReport builds the resource inventory from the legacy discovery documents.
+func buildResourceReport(docs *discoveryDocuments) *resourceReport {
+	preferredVersions := make(map[string]string)
+	for _, group := range docs.Groups.Groups {
+		preferredVersions[group.Name] = group.PreferredVersion.Version
+	}
+
+	var entries []resourceReportEntry
+	for gv, resourceList := range docs.Resources {
+		var (
+			byResource   = make(map[string]*resourceReportEntry)
+			subresources = make(map[string][]subresourceReportEntry)
+		)
+		for _, resource := range resourceList.APIResources {
+			if parent, sub, ok := strings.Cut(resource.Name, "/"); ok {
+				subresources[parent] = append(subresources[parent], subresourceReportEntry{
+					Name:  sub,
+					Kind:  resource.Kind,
+					Verbs: sortedStrings(resource.Verbs),
+				})
+				continue
+			}
+
+			byResource[resource.Name] = &resourceReportEntry{
+				Group:              gv.Group,
+				Version:            gv.Version,
+				Kind:               resource.Kind,
+				Resource:           resource.Name,
+				SingularName:       resource.SingularName,
+				Namespaced:         resource.Namespaced,
+				Verbs:              sortedStrings(resource.Verbs),
+				ShortNames:         resource.ShortNames,
+				Categories:         resource.Categories,
+				PreferredVersion:   preferredVersions[gv.Group] == gv.Version,
+				StorageVersionHash: resource.StorageVersionHash,
+			}
+		}
+
+		for parent, subs := range subresources {
+			entry, ok := byResource[parent]
+			if !ok {
+				continue
+			}
+			sort.Slice(subs, func(i, j int) bool {
+				return subs[i].Name < subs[j].Name
+			})
+			entry.Subresources = subs
+		}
+		for _, entry := range byResource {
+			entries = append(entries, *entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if gvi, gvj := entries[i].groupVersion().String(), entries[j].groupVersion().String(); gvi != gvj {
+			return gvi < gvj
+		}
+		return entries[i].Kind < entries[j].Kind
+	})
+	return &resourceReport{Resources: entries}
+}
+
+func sortedStrings(s []string) []string {
+	res := append([]string(nil), s...)
+	sort.Strings(res)
+	return res
+}
+
+// markdown renders the report as one markdown table per group version.
+func (r *resourceReport) markdown() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# API Resources\n")
+
+	var current schema.GroupVersion
+	for i, entry := range r.Resources {
+		if gv := entry.groupVersion(); i == 0 || gv != current {
+			current = gv
+			fmt.Fprintf(&buf, "\n## %s", gv)
+			if entry.PreferredVersion {
+				buf.WriteString(" (preferred)")
+			}
+			buf.WriteString("\n\n")
+			buf.WriteString("| Kind | Resource | Scope | Verbs | Subresources | Short Names | Categories | Storage Version Hash |\n")
+			buf.WriteString("|------|----------|-------|-------|--------------|-------------|------------|----------------------|\n")
+		}
+
+		scope := "Cluster"
+		if entry.Namespaced {
+			scope = "Namespaced"
+		}
+
+		subresources := make([]string, 0, len(entry.Subresources))
+		for _, sub := range entry.Subresources {
+			subresources = append(subresources, fmt.Sprintf("%s (%s)", sub.Name, strings.Join(sub.Verbs, ", ")))
+		}
+
+		fmt.Fprintf(&buf, "| %s | %s | %s | %s | %s | %s | %s | %s |\n",
+			entry.Kind,
+			entry.Resource,
+			scope,
+			markdownList(entry.Verbs),
+			markdownList(subresources),
+			markdownList(entry.ShortNames),
+			markdownList(entry.Categories),
+			markdownCode(entry.StorageVersionHash),
+		)
+	}
+	return buf.Bytes()
+}
+
+func markdownList(items []string) string {
+	if len(items) == 0 {
+		return "-"
+	}
+	return strings.Join(items, ", ")
+}
+
+func markdownCode(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return "`" + s + "`"
+}
+
+func writeResourceReport(outputDir string, report *resourceReport) error {
+	if err := writeJSONObject(outputDir, "resources.json", report); err != nil {
+		return fmt.Errorf("failed to write resource report: %w", err)
+	}
+
+	if err := writeFile(outputDir, "resources.md", report.markdown()); err != nil {
+		return fmt.Errorf("failed to write resource report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ironcore-dev/openapi-extractor/envtestutils/logfile"
+)
+
+const (
+	runSummaryFileName = "summary.json"
+)
+
+// runSummary records the outcome of a run of the openapi-extractor.
+type runSummary struct {
+	Started     time.Time           `json:"started"`
+	Finished    time.Time           `json:"finished"`
+	Succeeded   bool                `json:"succeeded"`
+	Error       string              `json:"error,omitempty"`
+	Extractions []*extractionRecord `json:"extractions"`
+}
+
+// extractionRecord records the extraction of a target from a single control plane.
+type extractionRecord struct {
+	Target       string `json:"target"`
+	ControlPlane string `json:"controlPlane"`
+	Succeeded    bool   `json:"succeeded"`
+	Error        string `json:"error,omitempty"`
+	// LogFiles are the paths of the captured log files by component.
+	LogFiles map[string]string `json:"logFiles,omitempty"`
+	// Diagnostics is the path of the diagnostics bundle collected on failure, if any.
+	Diagnostics string `json:"diagnostics,omitempty"`
+	// Kubeconfig is the path of the kubeconfig written for connecting to the environment kept running, if any.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+
+	logs []*logfile.File
+}
+
+// setupRunDir creates the run directory. If none was specified, a temporary directory is created
+// and generated is set.
+func setupRunDir() (generated bool, err error) {
+	if runDir == "" {
+		dir, err := os.MkdirTemp("", "openapi-extractor-run-")
+		if err != nil {
+			return false, fmt.Errorf("failed to create run directory: %w", err)
+		}
+		runDir = dir
+		return true, nil
+	}
+
+	if err := os.MkdirAll(runDir, 0750); err != nil {
+		return false, fmt.Errorf("failed to create run directory: %w", err)
+	}
+	return false, nil
+}
+
+// cleanupRunDir removes a generated run directory after a successful run. Otherwise, it is kept for inspection.
+func cleanupRunDir(generated bool, runErr error) {
+	if runErr != nil {
+		log.Info("Keeping run directory for inspection", "RunDirectory", runDir)
+		return
+	}
+	if !generated {
+		return
+	}
+	if err := os.RemoveAll(runDir); err != nil {
+		log.Error(err, "failed to remove run directory", "RunDirectory", runDir)
+	}
+}
+
+func (s *runSummary) newExtraction(target string, plane controlPlane) *extractionRecord {
+	rec := &extractionRecord{
+		Target:       target,
+		ControlPlane: plane.Name,
+	}
+	s.Extractions = append(s.Extractions, rec)
+	return rec
+}
+
+func (s *runSummary) write() error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run summary: %w", err)
+	}
+	return writeFile(runDir, runSummaryFileName, data)
+}
+
+// logDir returns the directory the log files of the extraction are stored in.
+func (r *extractionRecord) logDir() string {
+	return filepath.Join(runDir, r.Target, r.ControlPlane, "logs")
+}
+
+func (r *extractionRecord) addLogFiles(files ...*logfile.File) {
+	if r.LogFiles == nil {
+		r.LogFiles = make(map[string]string)
+	}
+	for _, file := range files {
+		if file == nil {
+			continue
+		}
+		r.logs = append(r.logs, file)
+		r.LogFiles[file.Component()] = file.Path()
+	}
+}
+
+// logOptions returns the options of the captured log files, keeping enough lines in memory
+// for --log-tail-lines and the diagnostics bundle.
+func logOptions() logfile.Options {
+	return logfile.Options{TailLines: max(logTailLines, diagnosticsLogLines)}
+}
+
+// dumpLogs writes the last n lines of every log of the extraction to w.
+func (r *extractionRecord) dumpLogs(w io.Writer, n int) {
+	if n == 0 {
+		return
+	}
+	for _, file := range r.logs {
+		lines := file.Tail(n)
+		_, _ = fmt.Fprintf(w, "==> Last %d lines of %s (%s) <==\n", len(lines), file.Component(), file.Path())
+		for _, line := range lines {
+			_, _ = fmt.Fprintln(w, line)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/sha512"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+//go:embed browser/index.html
+var browserHTML []byte
+
+// runServeCommand implements the `serve` command serving previously extracted specs under the paths of a
+// kube-apiserver, together with an HTML browser for them.
+func runServeCommand(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	s := &specServer{dir: "."}
+	setOutputConfigDefaults(&s.layout)
+	address := "127.0.0.1:8080"
+	fakeAPIServer := false
+	fs.StringVar(&s.dir, "spec-dir", s.dir, "Directory containing the extracted specs, i.e. the --output directory of an extraction")
+	fs.StringVar(&s.layout.V2File, "v2-file", s.layout.V2File, "Name of the OpenAPI v2 file within --spec-dir")
+	fs.StringVar(&s.layout.V3Dir, "v3-dir", s.layout.V3Dir, "Directory of the OpenAPI v3 files within --spec-dir")
+	fs.StringVar(&s.layout.DiscoveryDir, "discovery-dir", s.layout.DiscoveryDir, "Directory of the discovery files within --spec-dir, used with --fake-apiserver")
+	fs.StringVar(&address, "address", address, "Address to listen on")
+	fs.BoolVar(&fakeAPIServer, "fake-apiserver", fakeAPIServer, "Whether to additionally serve the discovery and an in-memory storage validating against the schemas for every resource, as a stand-in for the aggregated api server in client tests")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: openapi-extractor serve [flags]\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if _, err := os.Stat(s.dir); err != nil {
+		return fmt.Errorf("invalid --spec-dir: %w", err)
+	}
+
+	handler := s.handler()
+	if fakeAPIServer {
+		f, err := newFakeAPIServer(s)
+		if err != nil {
+			return err
+		}
+		handler = f.handler()
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx := ctrl.SetupSignalHandler()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving the specs of %s at http://%s, press Ctrl-C to stop\n", s.dir, listener.Addr())
+	if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// specServer serves the specs of an extraction from disk, so that a running server picks up new extractions.
+type specServer struct {
+	dir    string
+	layout outputConfig
+}
+
+func (s *specServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.serveBrowser)
+	mux.HandleFunc("GET /openapi/v2", s.serveV2)
+	mux.HandleFunc("GET /openapi/v3", s.serveV3Index)
+	mux.HandleFunc("GET /openapi/v3/{path...}", s.serveV3)
+	return mux
+}
+
+func (s *specServer) serveBrowser(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(browserHTML)
+}
+
+func (s *specServer) serveV2(w http.ResponseWriter, r *http.Request) {
+	s.serveFile(w, r, filepath.Join(s.dir, s.layout.V2File))
+}
+
+// openAPIV3Discovery is the document served at /openapi/v3, listing the OpenAPI v3 documents per group version.
+type openAPIV3Discovery struct {
+	Paths map[string]openAPIV3DiscoveryPath `json:"paths"`
+}
+
+type openAPIV3DiscoveryPath struct {
+	ServerRelativeURL string `json:"serverRelativeURL"`
+}
+
+func (s *specServer) serveV3Index(w http.ResponseWriter, _ *http.Request) {
+	files, err := s.v3Files()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	index := openAPIV3Discovery{Paths: make(map[string]openAPIV3DiscoveryPath, len(files))}
+	for path, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Like the kube-apiserver, the hash lets clients cache the documents.
+		index.Paths[path] = openAPIV3DiscoveryPath{
+			ServerRelativeURL: fmt.Sprintf("/openapi/v3/%s?hash=%X", path, sha512.Sum512(data)),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&index)
+}
+
+func (s *specServer) serveV3(w http.ResponseWriter, r *http.Request) {
+	files, err := s.v3Files()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	file, ok := files[r.PathValue("path")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveFile(w, r, file)
+}
+
+func (s *specServer) serveFile(w http.ResponseWriter, r *http.Request, file string) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// v3Files returns the OpenAPI v3 files by their path relative to /openapi/v3, e.g. apis/<group>/<version>.
+func (s *specServer) v3Files() (map[string]string, error) {
+	dir := filepath.Join(s.dir, s.layout.V3Dir)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read OpenAPI v3 directory: %w", err)
+	}
+
+	files := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		gv, ok := parseOpenAPIv3FileName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		files[strings.TrimPrefix(groupVersionPath(gv), "/")] = filepath.Join(dir, entry.Name())
+	}
+	return files, nil
+}
+
+// parseOpenAPIv3FileName is the inverse of openAPIv3FileName.
+func parseOpenAPIv3FileName(name string) (schema.GroupVersion, bool) {
+	trimmed, ok := strings.CutPrefix(name, "apis__")
+	if !ok {
+		return schema.GroupVersion{}, false
+	}
+	trimmed, ok = strings.CutSuffix(trimmed, "_openapi.json")
+	if !ok {
+		return schema.GroupVersion{}, false
+	}
+	group, version, ok := strings.Cut(trimmed, "__")
+	if !ok || version == "" {
+		return schema.GroupVersion{}, false
+	}
+	return schema.GroupVersion{Group: group, Version: version}, true
+}
@@ -0,0 +1,281 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/ironcore-dev/openapi-extractor/envtestutils"
+	"github.com/ironcore-dev/openapi-extractor/envtestutils/apiserver"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const (
+	serversDir = "servers"
+)
+
+// serverConfig describes one of several aggregated api servers registered in the same control plane.
+type serverConfig struct {
+	// Name identifies the server. It is used as name of the service of the server and as its output directory.
+	Name string `json:"name"`
+	// APIServer configures how to build and run the aggregated api server.
+	APIServer apiServerConfig `json:"apiServer"`
+	// APIServices are paths to the APIService definition files or directories served by this server.
+	APIServices []string `json:"apiServices,omitempty"`
+	// Port is the port the server listens on. If empty, a free port is chosen.
+	Port int `json:"port,omitempty"`
+}
+
+// servers returns the aggregated api servers of the target. Targets without explicit servers
+// consist of a single server named after the target.
+func (t *target) servers() []serverConfig {
+	if len(t.Servers) > 0 {
+		return t.Servers
+	}
+	return []serverConfig{{
+		Name:        t.Name,
+		APIServer:   t.APIServer,
+		APIServices: t.APIServices,
+	}}
+}
+
+// installOptions returns the options to install the APIServices of the server. If named is set,
+// the server gets its own service named after it.
+func (s *serverConfig) installOptions(named bool) envtestutils.APIServiceInstallOptions {
+	opts := envtestutils.APIServiceInstallOptions{
+		Paths:            s.APIServices,
+		LocalServingPort: s.Port,
+	}
+	if s.Port != 0 {
+		opts.LocalServingHost = "127.0.0.1"
+	}
+	if named {
+		opts.ServiceName = s.Name
+	}
+	return opts
+}
+
+// environmentExtensions returns the environment extensions registering all servers of the target.
+func environmentExtensions(servers []serverConfig) *envtestutils.EnvironmentExtensions {
+	named := len(servers) > 1
+	ext := &envtestutils.EnvironmentExtensions{
+		APIServiceInstallOptions:       servers[0].installOptions(named),
+		ErrorIfAPIServicePathIsMissing: true,
+	}
+	for i := range servers[1:] {
+		ext.AdditionalAPIServiceInstallOptions = append(ext.AdditionalAPIServiceInstallOptions, servers[i+1].installOptions(named))
+	}
+	return ext
+}
+
+// startAPIServers builds and starts all servers of the target in the order of servers. The returned function
+// stops all started servers.
+// With --verify-teardown, it reports what the servers left behind.
+// Starting is aborted once ctx is done, e.g. because the extractor received SIGINT or SIGTERM.
+func startAPIServers(
+	ctx context.Context,
+	log logr.Logger,
+	cfg *rest.Config,
+	env *envtest.Environment,
+	ext *envtestutils.EnvironmentExtensions,
+	servers []serverConfig,
+	rec *extractionRecord,
+) ([]*apiserver.APIServer, func() error, error) {
+	var buildCache *apiserver.BuildCache
+	if useBuildCache && buildCacheDir != "" {
+		buildCache = &apiserver.BuildCache{Dir: buildCacheDir}
+	}
+
+	var started []*apiserver.APIServer
+	stop := func() error {
+		var errs []error
+		for i := len(started) - 1; i >= 0; i-- {
+			if err := started[i].Stop(); err != nil {
+				log.Error(err, "failed to stop api server", "Server", servers[i].Name)
+			}
+			if verifyTeardown {
+				if err := started[i].VerifyStopped(); err != nil {
+					errs = append(errs, fmt.Errorf("server %s: %w", servers[i].Name, err))
+				}
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	for i, installOpts := range ext.AllAPIServiceInstallOptions() {
+		srv := &servers[i]
+		srvLog := log
+		name := "apiserver"
+		if len(servers) > 1 {
+			srvLog = log.WithValues("Server", srv.Name)
+			name = fmt.Sprintf("apiserver-%s", srv.Name)
+		}
+
+		buildOpts, err := srv.APIServer.Build.buildOptions()
+		if err != nil {
+			_ = stop()
+			return nil, nil, fmt.Errorf("server %s: invalid build options: %w", srv.Name, err)
+		}
+
+		// Servers on a fixed port are restarted on the same port, all others move to a fresh port.
+		restartPolicy := srv.APIServer.Restart.restartPolicy()
+		if srv.Port == 0 {
+			restartPolicy.BeforeRestart = func(int) (string, int, error) {
+				if err := installOpts.Reallocate(cfg); err != nil {
+					return "", 0, err
+				}
+				srvLog.Info("Moved api server to new port", "Port", installOpts.LocalServingPort)
+				return installOpts.LocalServingHost, installOpts.LocalServingPort, nil
+			}
+		}
+
+		healthProbe := apiserver.HealthProbe{
+			Paths:  srv.APIServer.Probe.Paths,
+			Exec:   srv.APIServer.Probe.Exec,
+			CAData: installOpts.LocalServingCAData,
+		}
+		if srv.APIServer.Probe.ClientCert {
+			healthProbe.ClientCertFile, healthProbe.ClientKeyFile = installOpts.ClientCertPaths()
+		}
+
+		apiSrv, err := apiserver.New(cfg, apiserver.Options{
+			AttachOutput:  attachAPIServerOutput,
+			Command:       srv.APIServer.Command,
+			MainPath:      srv.APIServer.Package,
+			BuildOptions:  buildOpts,
+			BuildCache:    buildCache,
+			Args:          srv.APIServer.Args,
+			DisabledArgs:  srv.APIServer.DisabledArgs,
+			Env:           envList(srv.APIServer.Env),
+			ETCDServers:   []string{env.ControlPlane.Etcd.URL.String()},
+			Host:          installOpts.LocalServingHost,
+			Port:          installOpts.LocalServingPort,
+			CertDir:       installOpts.LocalServingCertDir,
+			Name:          name,
+			LogDir:        rec.logDir(),
+			LogOptions:    logOptions(),
+			HealthProbe:   healthProbe,
+			RestartPolicy: restartPolicy,
+		})
+		if err != nil {
+			_ = stop()
+			return nil, nil, fmt.Errorf("server %s: failed to setup api server: %w", srv.Name, err)
+		}
+		rec.addLogFiles(apiSrv.LogFile())
+
+		srvLog.Info("Starting api server", "Host", installOpts.LocalServingHost, "Port", installOpts.LocalServingPort)
+		if err := apiSrv.StartWithContext(ctx); err != nil {
+			_ = apiSrv.Stop()
+			_ = stop()
+			return nil, nil, fmt.Errorf("server %s: failed to start api server: %w", srv.Name, err)
+		}
+		started = append(started, apiSrv)
+	}
+	return started, stop, nil
+}
+
+// serverResult contains the parts of an extraction served by a single aggregated api server.
+type serverResult struct {
+	name string
+	v2   []byte
+	gvs  []schema.GroupVersion
+}
+
+func (r *serverResult) write(outputDir string, layout *outputConfig, v3 map[schema.GroupVersion][]byte) error {
+	serverV3 := make(map[schema.GroupVersion][]byte, len(r.gvs))
+	for _, gv := range r.gvs {
+		serverV3[gv] = v3[gv]
+	}
+	return writeOpenAPI(filepath.Join(outputDir, serversDir, r.name), layout, r.v2, serverV3)
+}
+
+// filterOpenAPIv2 returns the OpenAPI v2 document reduced to the paths of the given group versions
+// and the definitions and parameters transitively referenced by them.
+func filterOpenAPIv2(data []byte, gvs []schema.GroupVersion) ([]byte, error) {
+	return reduceOpenAPIv2(data, func(path string) bool {
+		return slices.ContainsFunc(gvs, func(gv schema.GroupVersion) bool {
+			return hasGroupVersionPath([]string{path}, gv)
+		})
+	})
+}
+
+// excludeOpenAPIv2 returns the OpenAPI v2 document without the paths of the given group versions
+// and the definitions and parameters only referenced by them.
+func excludeOpenAPIv2(data []byte, gvs []schema.GroupVersion) ([]byte, error) {
+	return reduceOpenAPIv2(data, func(path string) bool {
+		return !slices.ContainsFunc(gvs, func(gv schema.GroupVersion) bool {
+			return hasGroupVersionPath([]string{path}, gv)
+		})
+	})
+}
+
+// reduceOpenAPIv2 returns the OpenAPI v2 document reduced to the paths to keep and the definitions
+// and parameters transitively referenced by them.
+func reduceOpenAPIv2(data []byte, keep func(path string) bool) ([]byte, error) {
+	doc, err := parseSpecDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := map[string]map[string]interface{}{
+		"definitions": {},
+		"parameters":  {},
+	}
+	res := make(map[string]interface{}, len(doc))
+	for key, value := range doc {
+		if _, ok := filtered[key]; !ok && key != "paths" {
+			res[key] = value
+		}
+	}
+
+	paths := make(map[string]interface{})
+	for path, item := range lookupObject(doc, "paths") {
+		if keep(path) {
+			paths[path] = item
+		}
+	}
+	res["paths"] = paths
+
+	queue := []interface{}{paths}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		walkReferences(node, func(ref string) {
+			section, name, ok := strings.Cut(strings.TrimPrefix(ref, "#/"), "/")
+			if !ok {
+				return
+			}
+			objs, ok := filtered[section]
+			if !ok {
+				return
+			}
+			name = strings.NewReplacer("~1", "/", "~0", "~").Replace(name)
+			if _, ok := objs[name]; ok {
+				return
+			}
+			target, err := resolveReference(doc, ref)
+			if err != nil {
+				return
+			}
+			objs[name] = target
+			queue = append(queue, target)
+		})
+	}
+	for section, objs := range filtered {
+		if _, ok := doc[section]; ok {
+			res[section] = objs
+		}
+	}
+
+	return json.Marshal(res)
+}
@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kube-openapi/pkg/spec3"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+const (
+	openAPIv2Version       = "2.0"
+	openAPIv3VersionPrefix = "3."
+)
+
+// validateOpenAPIv2 parses the given OpenAPI v2 document and verifies that it declares the expected
+// OpenAPI version, serves at least one path for each of the given group versions and that all its
+// references can be resolved.
+func validateOpenAPIv2(data []byte, gvs []schema.GroupVersion) error {
+	swagger := &spec.Swagger{}
+	if err := json.Unmarshal(data, swagger); err != nil {
+		return fmt.Errorf("failed to parse OpenAPI v2 document: %w", err)
+	}
+
+	var errs []error
+	if swagger.Swagger != openAPIv2Version {
+		errs = append(errs, fmt.Errorf("unexpected OpenAPI version %q, expected %q", swagger.Swagger, openAPIv2Version))
+	}
+
+	var paths []string
+	if swagger.Paths != nil {
+		for path := range swagger.Paths.Paths {
+			paths = append(paths, path)
+		}
+	}
+	for _, gv := range gvs {
+		if !hasGroupVersionPath(paths, gv) {
+			errs = append(errs, fmt.Errorf("no paths found for group version %s", gv))
+		}
+	}
+
+	if err := validateReferences(data); err != nil {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateOpenAPIv3 parses the given OpenAPI v3 document of the given group version and verifies that it
+// declares the expected OpenAPI version, serves at least one path for the group version and that all its
+// references can be resolved.
+func validateOpenAPIv3(data []byte, gv schema.GroupVersion) error {
+	openAPI := &spec3.OpenAPI{}
+	if err := json.Unmarshal(data, openAPI); err != nil {
+		return fmt.Errorf("failed to parse OpenAPI v3 document: %w", err)
+	}
+
+	var errs []error
+	if !strings.HasPrefix(openAPI.Version, openAPIv3VersionPrefix) {
+		errs = append(errs, fmt.Errorf("unexpected OpenAPI version %q, expected %s*", openAPI.Version, openAPIv3VersionPrefix))
+	}
+
+	var paths []string
+	if openAPI.Paths != nil {
+		for path := range openAPI.Paths.Paths {
+			paths = append(paths, path)
+		}
+	}
+	if !hasGroupVersionPath(paths, gv) {
+		errs = append(errs, fmt.Errorf("no paths found for group version %s", gv))
+	}
+
+	if err := validateReferences(data); err != nil {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func hasGroupVersionPath(paths []string, gv schema.GroupVersion) bool {
+	prefix := groupVersionPath(gv)
+	for _, path := range paths {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func groupVersionPath(gv schema.GroupVersion) string {
+	if gv.Group == "" {
+		return fmt.Sprintf("/api/%s", gv.Version)
+	}
+	return fmt.Sprintf("/apis/%s/%s", gv.Group, gv.Version)
+}
+
+// validateReferences verifies that every local JSON reference ($ref) in the given document
+// points to an existing location within the same document.
+func validateReferences(data []byte) error {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse document: %w", err)
+	}
+
+	unresolved := sets.New[string]()
+	walkReferences(doc, func(ref string) {
+		if _, err := resolveReference(doc, ref); err != nil {
+			unresolved.Insert(ref)
+		}
+	})
+	if unresolved.Len() > 0 {
+		refs := unresolved.UnsortedList()
+		sort.Strings(refs)
+		return fmt.Errorf("unresolvable references: %v", refs)
+	}
+	return nil
+}
+
+func walkReferences(node interface{}, f func(ref string)) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, value := range n {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				f(ref)
+				continue
+			}
+			walkReferences(value, f)
+		}
+	case []interface{}:
+		for _, value := range n {
+			walkReferences(value, f)
+		}
+	}
+}
+
+func resolveReference(doc interface{}, ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("non-local reference %q", ref)
+	}
+	pointer, err := url.PathUnescape(strings.TrimPrefix(ref, "#"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference %q: %w", ref, err)
+	}
+	if pointer == "" {
+		return doc, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid reference %q", ref)
+	}
+
+	cur := doc
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("reference %q does not point to an object", ref)
+		}
+		if cur, ok = obj[token]; !ok {
+			return nil, fmt.Errorf("reference %q not found", ref)
+		}
+	}
+	return cur, nil
+}
@@ -0,0 +1,311 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	"github.com/ironcore-dev/openapi-extractor/envtestutils"
+	"github.com/ironcore-dev/openapi-extractor/envtestutils/apiserver"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// watchDebounce is the time without further changes after which a change triggers a new extraction.
+	watchDebounce = 500 * time.Millisecond
+)
+
+// targetWatcher re-extracts a target whenever the sources of its aggregated api servers or
+// their APIService definitions change, while the control plane keeps running.
+type targetWatcher struct {
+	log        logr.Logger
+	target     *target
+	cfg        *rest.Config
+	ext        *envtestutils.EnvironmentExtensions
+	servers    []serverConfig
+	apiServers []*apiserver.APIServer
+	client     client.Client
+	clientSet  *kubernetes.Clientset
+	rec        *extractionRecord
+	out        io.Writer
+
+	fsWatcher *fsnotify.Watcher
+	sources   []serverSources
+
+	last    *extractionResult
+	lastErr error
+}
+
+// serverSources are the watched files of a single aggregated api server.
+type serverSources struct {
+	// packageDirs are the directories of the Go packages the server is built from.
+	packageDirs sets.Set[string]
+	// manifestFiles and manifestDirs are the APIService definition files and directories. The files include
+	// all files read when building kustomizations.
+	manifestFiles sets.Set[string]
+	manifestDirs  sets.Set[string]
+}
+
+func (s *serverSources) sourcesChanged(changed sets.Set[string]) bool {
+	for path := range changed {
+		if s.packageDirs.Has(filepath.Dir(path)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *serverSources) manifestsChanged(changed sets.Set[string]) bool {
+	for path := range changed {
+		if s.manifestFiles.Has(path) {
+			return true
+		}
+		switch filepath.Ext(path) {
+		case ".json", ".yaml", ".yml":
+			if s.manifestDirs.Has(filepath.Dir(path)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dirs returns the directories to watch for the server.
+func (s *serverSources) dirs() sets.Set[string] {
+	dirs := s.packageDirs.Union(s.manifestDirs)
+	for file := range s.manifestFiles {
+		dirs.Insert(filepath.Dir(file))
+	}
+	return dirs
+}
+
+// run handles the result of the initial extraction and then watches for changes until ctx is done.
+// It returns the result of the last extraction.
+func (w *targetWatcher) run(ctx context.Context, res *extractionResult, extractErr error) (*extractionResult, error) {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer func() { _ = fsWatcher.Close() }()
+	w.fsWatcher = fsWatcher
+
+	w.handleResult(ctx, res, extractErr)
+	if err := w.updateWatches(); err != nil {
+		return nil, err
+	}
+	w.log.Info("Watching for changes, press Ctrl-C to stop")
+
+	var (
+		pending = sets.New[string]()
+		timer   = time.NewTimer(watchDebounce)
+	)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return w.last, w.lastErr
+		case event, ok := <-fsWatcher.Events:
+			if !ok {
+				return w.last, w.lastErr
+			}
+			if !relevantEvent(event) {
+				continue
+			}
+			pending.Insert(filepath.Clean(event.Name))
+			timer.Reset(watchDebounce)
+		case err, ok := <-fsWatcher.Errors:
+			if !ok {
+				return w.last, w.lastErr
+			}
+			w.log.Error(err, "error watching for changes")
+		case <-timer.C:
+			changed := pending
+			pending = sets.New[string]()
+			w.cycle(ctx, changed)
+		}
+	}
+}
+
+// relevantEvent filters out attribute changes and the temporary files of editors.
+func relevantEvent(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	name := filepath.Base(event.Name)
+	return !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, "~")
+}
+
+// cycle reloads the APIServices and restarts the api servers affected by the changed files
+// and extracts the target again.
+func (w *targetWatcher) cycle(ctx context.Context, changed sets.Set[string]) {
+	allOpts := w.ext.AllAPIServiceInstallOptions()
+	affected := false
+	for i := range w.servers {
+		srv, src := &w.servers[i], &w.sources[i]
+		srvLog := w.log
+		if len(w.servers) > 1 {
+			srvLog = w.log.WithValues("Server", srv.Name)
+		}
+
+		if src.manifestsChanged(changed) {
+			affected = true
+			reloaded, err := allOpts[i].ReloadAPIServices(w.cfg)
+			if err != nil {
+				srvLog.Error(err, "failed to reload APIServices")
+			} else if reloaded {
+				srvLog.Info("Re-applied changed APIServices")
+			}
+		}
+		if src.sourcesChanged(changed) {
+			affected = true
+			srvLog.Info("Sources changed, rebuilding and restarting api server")
+			if err := w.apiServers[i].Restart(ctx); err != nil {
+				srvLog.Error(err, "failed to restart api server")
+			}
+		}
+	}
+	if !affected {
+		return
+	}
+
+	if err := w.updateWatches(); err != nil {
+		w.log.Error(err, "failed to update watched directories")
+	}
+	res, err := extract(ctx, w.log, w.target, w.servers, w.ext, w.client, w.clientSet, w.rec)
+	w.handleResult(ctx, res, err)
+}
+
+// handleResult writes the outputs of a successful extraction and prints how they differ from the previous ones.
+func (w *targetWatcher) handleResult(ctx context.Context, res *extractionResult, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		w.lastErr = err
+		w.log.Error(err, "Extraction failed, waiting for changes")
+		return
+	}
+
+	if w.last != nil {
+		if diff, err := diffResults(w.last, res); err != nil {
+			w.log.Error(err, "failed to compare with the previous extraction")
+		} else {
+			printDiffSummary(w.out, diff)
+		}
+	}
+
+	if err := res.write(w.target.Output.Dir, &w.target.Output); err != nil {
+		w.lastErr = err
+		w.log.Error(err, "failed to write results")
+		return
+	}
+	if err := runPostProcess(ctx, w.log, w.target); err != nil {
+		w.lastErr = err
+		w.log.Error(err, "failed to post-process outputs")
+		return
+	}
+	w.last, w.lastErr = res, nil
+	w.log.Info("Extraction succeeded, waiting for changes")
+}
+
+func printDiffSummary(w io.Writer, diff *versionDiff) {
+	if diff.V2 == nil && len(diff.V3) == 0 {
+		_, _ = fmt.Fprintf(w, "No changes since the previous extraction.\n")
+		return
+	}
+	_, _ = fmt.Fprintf(w, "Changes since the previous extraction:\n")
+	if diff.V2 != nil {
+		_, _ = fmt.Fprintf(w, "\tOpenAPI v2: %s\n", diff.V2.summary("definitions"))
+	}
+	for i := range diff.V3 {
+		_, _ = fmt.Fprintf(w, "\tOpenAPI v3 %s: %s\n", diff.V3[i].GroupVersion, diff.V3[i].summary("schemas"))
+	}
+}
+
+// updateWatches determines the sources of all servers and watches their directories. The Go package
+// directories are determined again every time, as changed sources may import different packages.
+func (w *targetWatcher) updateWatches() error {
+	sources := make([]serverSources, 0, len(w.servers))
+	for i := range w.servers {
+		src, err := w.serverSources(&w.servers[i])
+		if err != nil {
+			return fmt.Errorf("server %s: %w", w.servers[i].Name, err)
+		}
+		sources = append(sources, src)
+	}
+
+	dirs := sets.New[string]()
+	for i := range sources {
+		dirs = dirs.Union(sources[i].dirs())
+	}
+	for _, dir := range w.fsWatcher.WatchList() {
+		if !dirs.Has(dir) {
+			_ = w.fsWatcher.Remove(dir)
+		}
+	}
+	for _, dir := range sets.List(dirs) {
+		if err := w.fsWatcher.Add(dir); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+	w.sources = sources
+	return nil
+}
+
+func (w *targetWatcher) serverSources(srv *serverConfig) (serverSources, error) {
+	src := serverSources{
+		packageDirs:   sets.New[string](),
+		manifestFiles: sets.New[string](),
+		manifestDirs:  sets.New[string](),
+	}
+
+	if srv.APIServer.Package != "" {
+		buildOpts, err := srv.APIServer.Build.buildOptions()
+		if err != nil {
+			return src, fmt.Errorf("invalid build options: %w", err)
+		}
+		dirs, err := apiserver.SourceDirs(srv.APIServer.Package, buildOpts)
+		if err != nil {
+			return src, fmt.Errorf("failed to determine api server sources: %w", err)
+		}
+		src.packageDirs.Insert(dirs...)
+	}
+
+	for _, path := range srv.APIServices {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return src, fmt.Errorf("failed to determine absolute path of %s: %w", path, err)
+		}
+		info, err := os.Stat(absPath)
+		if err != nil {
+			return src, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		switch {
+		case !info.IsDir():
+			src.manifestFiles.Insert(absPath)
+		case envtestutils.IsKustomization(absPath):
+			// Kustomizations may reference bases and patches outside their directory.
+			files, err := envtestutils.KustomizationFiles(absPath)
+			if err != nil {
+				return src, fmt.Errorf("failed to determine files of kustomization %s: %w", path, err)
+			}
+			src.manifestFiles.Insert(files...)
+		default:
+			src.manifestDirs.Insert(absPath)
+		}
+	}
+	return src, nil
+}
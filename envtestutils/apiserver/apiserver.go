@@ -5,27 +5,30 @@ package apiserver
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/ironcore-dev/controller-utils/buildutils"
+	"github.com/ironcore-dev/openapi-extractor/envtestutils/logfile"
 	"github.com/ironcore-dev/openapi-extractor/internal/testing/controlplane"
 	"k8s.io/apimachinery/pkg/util/sets"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+var log = ctrl.Log.WithName("apiserver")
+
 type ProcessArgs map[string][]string
 
 func EmptyProcessArgs() ProcessArgs {
@@ -37,16 +40,39 @@ func (p ProcessArgs) Set(key string, values ...string) ProcessArgs {
 	return p
 }
 
+// Runner runs an api server in-process with the given arguments (e.g. --etcd-servers, --kubeconfig,
+// --secure-port and the serving certificates). It has to block until ctx is done or the server failed
+// and may only return once the server stopped serving.
+type Runner func(ctx context.Context, args []string) error
+
+// RestartPolicy configures how often starting the api server is attempted until it becomes healthy.
+type RestartPolicy struct {
+	// MaxAttempts is the maximum number of start attempts. Defaults to 1, i.e. no restarts.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first restart. It doubles with every further restart.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between restarts.
+	MaxBackoff time.Duration
+	// BeforeRestart is called before each restart and returns the host and port to use for the next
+	// attempt, e.g. to move away from a port that was taken by another process meanwhile.
+	BeforeRestart func(attempt int) (host string, port int, err error)
+}
+
 type APIServer struct {
 	mainPackage  string
-	buildOptions []buildutils.BuildOption
+	buildOptions BuildOptions
+	buildCache   *BuildCache
 	command      []string
 	cmd          *exec.Cmd
+	runner       Runner
+	cancelRunner context.CancelFunc
 
-	config      *rest.Config
-	etcdServers []string
-	args        ProcessArgs
-	mergeArgs   func(customArgs, defaultArgs ProcessArgs) ProcessArgs
+	config       *rest.Config
+	etcdServers  []string
+	args         ProcessArgs
+	disabledArgs []string
+	mergeArgs    func(customArgs, defaultArgs ProcessArgs) ProcessArgs
+	env          []string
 
 	host    string
 	port    int
@@ -54,8 +80,12 @@ type APIServer struct {
 	stdout  io.Writer
 	stderr  io.Writer
 
+	logFile *logfile.File
+
 	healthTimeout time.Duration
+	healthProbe   HealthProbe
 	waitTimeout   time.Duration
+	restartPolicy RestartPolicy
 
 	waitDone chan struct{}
 	errMu    sync.Mutex
@@ -66,10 +96,14 @@ type APIServer struct {
 
 type Options struct {
 	MainPath     string
-	BuildOptions []buildutils.BuildOption
+	BuildOptions BuildOptions
+	BuildCache   *BuildCache
 	Command      []string
+	Runner       Runner
 	Args         ProcessArgs
+	DisabledArgs []string
 	MergeArgs    func(customArgs, defaultArgs ProcessArgs) ProcessArgs
+	Env          []string
 
 	ETCDServers []string
 	Host        string
@@ -80,8 +114,14 @@ type Options struct {
 	Stdout       io.Writer
 	Stderr       io.Writer
 
+	Name       string
+	LogDir     string
+	LogOptions logfile.Options
+
 	HealthTimeout time.Duration
+	HealthProbe   HealthProbe
 	WaitTimeout   time.Duration
+	RestartPolicy RestartPolicy
 }
 
 func MergeArgs(customArgs, defaultArgs ProcessArgs) ProcessArgs {
@@ -118,11 +158,26 @@ func setAPIServerOptionsDefaults(opts *Options) {
 	if opts.Args == nil {
 		opts.Args = make(ProcessArgs)
 	}
+	if opts.Name == "" {
+		opts.Name = "apiserver"
+	}
+	if opts.RestartPolicy.MaxAttempts <= 0 {
+		opts.RestartPolicy.MaxAttempts = 1
+	}
+	if opts.RestartPolicy.InitialBackoff == 0 {
+		opts.RestartPolicy.InitialBackoff = 1 * time.Second
+	}
+	if opts.RestartPolicy.MaxBackoff == 0 {
+		opts.RestartPolicy.MaxBackoff = 10 * time.Second
+	}
 }
 
 func New(cfg *rest.Config, opts Options) (*APIServer, error) {
-	if opts.MainPath == "" && len(opts.Command) == 0 {
-		return nil, fmt.Errorf("must specify opts.MainPath or opts.Command")
+	if opts.MainPath == "" && len(opts.Command) == 0 && opts.Runner == nil {
+		return nil, fmt.Errorf("must specify opts.MainPath, opts.Command or opts.Runner")
+	}
+	if opts.Runner != nil && (opts.MainPath != "" || len(opts.Command) > 0) {
+		return nil, fmt.Errorf("must not specify opts.Runner together with opts.MainPath or opts.Command")
 	}
 	if opts.AttachOutput && (opts.Stdout != nil || opts.Stderr != nil) {
 		return nil, fmt.Errorf("must not specify AttachOutput and Stdout / Stderr simultaneously")
@@ -132,60 +187,284 @@ func New(cfg *rest.Config, opts Options) (*APIServer, error) {
 	}
 	setAPIServerOptionsDefaults(&opts)
 
+	var logFile *logfile.File
+	if opts.LogDir != "" {
+		var err error
+		logFile, err = logfile.Open(opts.LogDir, opts.Name, opts.LogOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error opening log file: %w", err)
+		}
+	}
+
 	return &APIServer{
 		mainPackage:   opts.MainPath,
 		buildOptions:  opts.BuildOptions,
+		buildCache:    opts.BuildCache,
 		command:       opts.Command,
+		runner:        opts.Runner,
 		config:        cfg,
 		etcdServers:   opts.ETCDServers,
 		args:          opts.Args,
+		disabledArgs:  opts.DisabledArgs,
 		mergeArgs:     opts.MergeArgs,
+		env:           opts.Env,
 		host:          opts.Host,
 		port:          opts.Port,
 		certDir:       opts.CertDir,
 		stdout:        opts.Stdout,
 		stderr:        opts.Stderr,
+		logFile:       logFile,
 		healthTimeout: opts.HealthTimeout,
+		healthProbe:   opts.HealthProbe,
 		waitTimeout:   opts.WaitTimeout,
+		restartPolicy: opts.RestartPolicy,
 	}, nil
 }
 
+// LogFile returns the file the output of the api server is captured in, if any.
+func (a *APIServer) LogFile() *logfile.File {
+	return a.logFile
+}
+
+func (a *APIServer) closeLogs() {
+	if a.logFile != nil {
+		_ = a.logFile.Close()
+	}
+}
+
+// outputWriter returns the writer for an output stream of the api server process,
+// capturing it into the log file if configured. The writers capturing into the log file
+// are added to logWriters, to be closed once the process exited.
+func (a *APIServer) outputWriter(w io.Writer, logWriters *[]io.Closer) io.Writer {
+	if a.logFile == nil {
+		return w
+	}
+
+	logWriter := a.logFile.Writer()
+	*logWriters = append(*logWriters, logWriter)
+	if w == nil {
+		return logWriter
+	}
+	return io.MultiWriter(w, logWriter)
+}
+
+// closeLogWriters closes the log writers of a process, flushing a partial last line.
+func closeLogWriters(logWriters []io.Closer) {
+	for _, w := range logWriters {
+		_ = w.Close()
+	}
+}
+
 func (a *APIServer) Exited() (bool, error) {
 	a.errMu.Lock()
 	defer a.errMu.Unlock()
 	return a.exited, a.exitErr
 }
 
+// Start starts the api server and waits until it is healthy. If it exits or does not become
+// healthy in time, it is restarted according to the RestartPolicy.
 func (a *APIServer) Start() error {
+	return a.StartWithContext(context.Background())
+}
+
+// StartWithContext is like Start but gives up waiting for the api server to become healthy
+// and restarting it once ctx is done.
+func (a *APIServer) StartWithContext(ctx context.Context) error {
 	var err error
 	a.dir, err = a.setupTempDir()
 	if err != nil {
 		return fmt.Errorf("error setting up temp dir: %w", err)
 	}
+	return a.start(ctx)
+}
+
+// Restart rebuilds the api server and replaces the running process by a new one, restarting it
+// according to the RestartPolicy. If the api server cannot be built, the running process is kept.
+func (a *APIServer) Restart(ctx context.Context) error {
+	dir, err := a.setupTempDir()
+	if err != nil {
+		return fmt.Errorf("error setting up temp dir: %w", err)
+	}
+	if err := a.stopProcess(); err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	if a.dir != "" {
+		_ = os.RemoveAll(a.dir)
+	}
+	a.dir = dir
+	return a.start(ctx)
+}
+
+func (a *APIServer) start(ctx context.Context) error {
+	var (
+		errs    []error
+		attempt int
+		backoff = a.restartPolicy.InitialBackoff
+	)
+	for attempt = 1; ; attempt++ {
+		err := a.startProcess(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("attempt %d (port %d): %w", attempt, a.port, err))
+		if attempt >= a.restartPolicy.MaxAttempts || ctx.Err() != nil {
+			break
+		}
+
+		a.killProcess()
+		log.Info("Restarting api server", "Attempt", attempt+1, "Backoff", backoff, "Error", err.Error())
+		select {
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+		case <-time.After(backoff):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		backoff = min(2*backoff, a.restartPolicy.MaxBackoff)
+
+		if a.restartPolicy.BeforeRestart != nil {
+			host, port, err := a.restartPolicy.BeforeRestart(attempt + 1)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error preparing attempt %d: %w", attempt+1, err))
+				break
+			}
+			a.host, a.port = host, port
+		}
+	}
+
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return fmt.Errorf("api server did not become healthy after %d attempts: %w", attempt, errors.Join(errs...))
+}
+
+// started reports whether a process or runner was started.
+func (a *APIServer) started() bool {
+	return a.cancelRunner != nil || (a.cmd != nil && a.cmd.Process != nil)
+}
+
+// terminate asks the api server and the processes it started to shut down gracefully.
+func (a *APIServer) terminate() error {
+	if a.cancelRunner != nil {
+		a.cancelRunner()
+		return nil
+	}
+	return signalProcessGroup(a.cmd.Process, syscall.SIGTERM)
+}
+
+// kill stops the api server and the processes it started immediately.
+// An in-process runner can only be cancelled.
+func (a *APIServer) kill() {
+	if a.cancelRunner != nil {
+		a.cancelRunner()
+		return
+	}
+	_ = signalProcessGroup(a.cmd.Process, syscall.SIGKILL)
+}
+
+// killProcess kills the process of a failed start attempt and waits for it to exit.
+func (a *APIServer) killProcess() {
+	if !a.started() || a.waitDone == nil {
+		return
+	}
+	if done, _ := a.Exited(); !done {
+		a.kill()
+	}
+
+	t := time.NewTimer(a.waitTimeout)
+	defer t.Stop()
+	select {
+	case <-a.waitDone:
+	case <-t.C:
+	}
+}
+
+func (a *APIServer) startProcess(ctx context.Context) error {
+	a.errMu.Lock()
+	a.exited = false
+	a.exitErr = nil
+	a.errMu.Unlock()
+	a.waitDone = nil
+
+	if a.runner != nil {
+		a.startRunner()
+		return a.waitHealthy(ctx)
+	}
+
+	cmd, logWriters := a.createCmd()
+	a.cmd = cmd
+	var (
+		startErr = make(chan error, 1)
+		waitDone = make(chan struct{})
+	)
+	go func() {
+		defer close(waitDone)
+		// On Linux, the parent death signal is sent once the thread that started the process exits.
+		// Keep the thread locked, and thus alive, until the process exited.
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		if err := cmd.Start(); err != nil {
+			closeLogWriters(logWriters)
+			startErr <- err
+			return
+		}
+		startErr <- nil
+
+		err := cmd.Wait()
+		// Wait returns once the output of the process was copied completely.
+		closeLogWriters(logWriters)
 
-	a.cmd = a.createCmd()
-	if err := a.cmd.Start(); err != nil {
+		a.errMu.Lock()
+		defer a.errMu.Unlock()
+		a.exitErr = err
+		a.exited = true
+	}()
+	if err := <-startErr; err != nil {
 		a.errMu.Lock()
 		defer a.errMu.Unlock()
 		a.exited = true
 		return fmt.Errorf("error starting api server: %w", err)
 	}
 
+	a.waitDone = waitDone
+	return a.waitHealthy(ctx)
+}
+
+// startRunner runs the in-process runner in a goroutine.
+func (a *APIServer) startRunner() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancelRunner = cancel
 	a.waitDone = make(chan struct{})
+	args := a.processArgs()
 	go func() {
 		defer close(a.waitDone)
-		err := a.cmd.Wait()
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("api server runner panicked: %v", r)
+			}
+			if errors.Is(err, context.Canceled) {
+				err = nil
+			}
 
-		a.errMu.Lock()
-		defer a.errMu.Unlock()
-		a.exitErr = err
-		a.exited = true
+			a.errMu.Lock()
+			defer a.errMu.Unlock()
+			a.exitErr = err
+			a.exited = true
+		}()
+		err = a.runner(ctx, args)
 	}()
+}
 
+// waitHealthy waits until the started api server is healthy or exited.
+func (a *APIServer) waitHealthy(ctx context.Context) error {
 	var (
 		healthDone              = make(chan struct{})
 		healthErr               error
-		healthCtx, healthCancel = context.WithTimeout(context.Background(), a.healthTimeout)
+		healthCtx, healthCancel = context.WithTimeout(ctx, a.healthTimeout)
 	)
 	defer healthCancel()
 	go func() {
@@ -203,9 +482,9 @@ func (a *APIServer) Start() error {
 		return fmt.Errorf("wait returned before ready")
 	case <-healthDone:
 		if healthErr != nil {
-			if a.cmd != nil {
+			if a.started() {
 				// intentionally ignore this -- we might've crashed, failed to start, etc
-				_ = a.cmd.Process.Signal(syscall.SIGTERM)
+				_ = a.terminate()
 			}
 			return fmt.Errorf("healthiness check returned an error: %w", healthErr)
 		}
@@ -216,54 +495,81 @@ func (a *APIServer) Start() error {
 
 func (a *APIServer) Stop() error {
 	defer func() {
+		a.closeLogs()
 		if a.dir != "" {
 			_ = os.RemoveAll(a.dir)
 		}
 	}()
-	if a.cmd == nil {
+	return a.stopProcess()
+}
+
+// stopProcess stops the api server gracefully and kills it if it does not stop in time.
+func (a *APIServer) stopProcess() error {
+	if !a.started() {
 		return nil
 	}
 	if done, _ := a.Exited(); done {
+		if a.waitDone != nil {
+			<-a.waitDone
+		}
 		return nil
 	}
-	if err := a.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+	if err := a.terminate(); err != nil {
 		return fmt.Errorf("unable to signal for process to stop: %w", err)
 	}
 
 	t := time.NewTimer(a.waitTimeout)
 	defer t.Stop()
 
+	select {
+	case <-a.waitDone:
+		a.reapProcessGroup()
+		return nil
+	case <-t.C:
+	}
+
+	// The api server did not shut down gracefully in time, kill it.
+	log.Info("Timeout waiting for api server to stop, killing it", "Timeout", a.waitTimeout)
+	a.kill()
+	t.Reset(a.waitTimeout)
 	select {
 	case <-a.waitDone:
 		return nil
 	case <-t.C:
-		return fmt.Errorf("timeout waiting for process to stop")
+		return fmt.Errorf("timeout waiting for process to stop after killing it")
 	}
 }
 
-func (a *APIServer) pollHealthCheck(ctx context.Context) error {
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true, // skip verify for doing local health checks is ok.
-			},
-		},
+// reapProcessGroup kills any process the api server started that outlived it.
+func (a *APIServer) reapProcessGroup() {
+	if a.cmd != nil && a.cmd.Process != nil {
+		_ = signalProcessGroup(a.cmd.Process, syscall.SIGKILL)
 	}
+}
 
-	return wait.PollUntilContextCancel(ctx, 1*time.Second, true, func(ctx context.Context) (done bool, err error) {
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s:%d/readyz", a.host, a.port), nil)
-		if err != nil {
-			return false, fmt.Errorf("error creating health request: %w", err)
+// VerifyStopped reports the temporary directory and the processes of the api server
+// that are left behind after Stop.
+func (a *APIServer) VerifyStopped() error {
+	var leaks []string
+	if a.dir != "" {
+		if _, err := os.Stat(a.dir); err == nil {
+			leaks = append(leaks, fmt.Sprintf("directory %s", a.dir))
 		}
-
-		res, err := httpClient.Do(req)
-		if err != nil {
-			return false, nil
+	}
+	if a.cmd != nil && a.cmd.Process != nil && processGroupAlive(a.cmd.Process) {
+		leaks = append(leaks, fmt.Sprintf("process group %d", a.cmd.Process.Pid))
+	}
+	if a.runner != nil && a.waitDone != nil {
+		select {
+		case <-a.waitDone:
+		default:
+			leaks = append(leaks, "in-process runner")
 		}
-
-		_ = res.Body.Close()
-		return res.StatusCode == http.StatusOK, nil
-	})
+	}
+	if len(leaks) > 0 {
+		return fmt.Errorf("left behind after stopping: %s", strings.Join(leaks, ", "))
+	}
+	return nil
 }
 
 func (a *APIServer) setupTempDir() (string, error) {
@@ -272,14 +578,24 @@ func (a *APIServer) setupTempDir() (string, error) {
 		return "", fmt.Errorf("error creating temp directory")
 	}
 
-	if a.mainPackage != "" {
+	if a.mainPackage != "" && a.buildCache != nil {
+		binary, err := a.buildCache.Build(a.mainPackage, a.buildOptions)
+		if err != nil {
+			_ = os.RemoveAll(tmpDir)
+			return "", fmt.Errorf("error building api server binary: %w", err)
+		}
+
+		a.command = []string{binary}
+	} else if a.mainPackage != "" {
 		apiSrvBinary, err := os.CreateTemp(tmpDir, "apiserver")
 		if err != nil {
 			_ = os.RemoveAll(tmpDir)
 			return "", fmt.Errorf("error creating api server binary file")
 		}
 
-		if err := buildutils.Build(a.mainPackage, apiSrvBinary.Name(), a.buildOptions...); err != nil {
+		_ = apiSrvBinary.Close()
+
+		if err := Build(a.mainPackage, apiSrvBinary.Name(), a.buildOptions); err != nil {
 			_ = os.RemoveAll(tmpDir)
 			return "", fmt.Errorf("error building api server binary: %w", err)
 		}
@@ -300,7 +616,8 @@ func (a *APIServer) setupTempDir() (string, error) {
 	return tmpDir, nil
 }
 
-func (a *APIServer) createCmd() *exec.Cmd {
+// processArgs returns the arguments of the api server: the default arguments merged with the custom ones.
+func (a *APIServer) processArgs() []string {
 	kubeconfig := filepath.Join(a.dir, "kubeconfig")
 	defaultArgs := ProcessArgs{
 		"etcd-servers":                 a.etcdServers,
@@ -316,6 +633,9 @@ func (a *APIServer) createCmd() *exec.Cmd {
 		"tls-cert-file":                []string{path.Join(a.certDir, "tls.crt")},
 		"tls-private-key-file":         []string{path.Join(a.certDir, "tls.key")},
 	}
+	for _, key := range a.disabledArgs {
+		delete(defaultArgs, key)
+	}
 	args := a.mergeArgs(a.args, defaultArgs)
 
 	keySet := sets.NewString()
@@ -337,23 +657,34 @@ func (a *APIServer) createCmd() *exec.Cmd {
 	sort.Strings(additionalKeys)
 
 	keys := append(defaultKeys, additionalKeys...)
-	var execArgs []string
-	if len(a.command) > 1 {
-		execArgs = append(execArgs, a.command[1:]...)
-	}
+	var res []string
 	for _, key := range keys {
 		values := args[key]
 		switch len(values) {
 		case 0:
-			execArgs = append(execArgs, fmt.Sprintf("--%s", key))
+			res = append(res, fmt.Sprintf("--%s", key))
 		default:
 			for _, val := range values {
-				execArgs = append(execArgs, fmt.Sprintf("--%s=%s", key, val))
+				res = append(res, fmt.Sprintf("--%s=%s", key, val))
 			}
 		}
 	}
+	return res
+}
+
+func (a *APIServer) createCmd() (*exec.Cmd, []io.Closer) {
+	var execArgs []string
+	if len(a.command) > 1 {
+		execArgs = append(execArgs, a.command[1:]...)
+	}
+	execArgs = append(execArgs, a.processArgs()...)
 	cmd := exec.Command(a.command[0], execArgs...)
-	cmd.Stdout = a.stdout
-	cmd.Stderr = a.stderr
-	return cmd
+	if len(a.env) > 0 {
+		cmd.Env = append(os.Environ(), a.env...)
+	}
+	cmd.SysProcAttr = sysProcAttr()
+	var logWriters []io.Closer
+	cmd.Stdout = a.outputWriter(a.stdout, &logWriters)
+	cmd.Stderr = a.outputWriter(a.stderr, &logWriters)
+	return cmd, logWriters
 }
@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package apiserver
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ModMode is the module download mode used when building.
+type ModMode string
+
+const (
+	// ModModeVendor causes modules to be resolved from a vendor folder.
+	ModModeVendor ModMode = "vendor"
+	// ModModeReadonly expects all modules to be present in the module cache for the current module.
+	ModModeReadonly ModMode = "readonly"
+	// ModModeMod fetches any module before building.
+	ModModeMod ModMode = "mod"
+)
+
+// ParseModMode parses the given string as ModMode.
+func ParseModMode(s string) (ModMode, error) {
+	switch mode := ModMode(s); mode {
+	case ModModeVendor, ModModeReadonly, ModModeMod:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown mod mode %q, must be one of %s, %s, %s", s, ModModeVendor, ModModeReadonly, ModModeMod)
+	}
+}
+
+// BuildOptions configure how the api server binary is built from its main package.
+type BuildOptions struct {
+	// Tags are the build tags (-tags).
+	Tags []string
+	// LDFlags are the linker flags (-ldflags).
+	LDFlags []string
+	// VersionStamps are variables to set at link time (-ldflags -X), e.g.
+	// "k8s.io/component-base/version.gitVersion" -> "v1.0.0".
+	VersionStamps map[string]string
+	// TrimPath removes file system paths from the binary (-trimpath).
+	TrimPath bool
+	// Mod is the module download mode (-mod).
+	Mod ModMode
+	// GoFlags are additional flags passed via the GOFLAGS environment variable.
+	GoFlags []string
+	// Env are additional environment variables (KEY=VALUE) for the build, e.g. CGO_ENABLED=0 or GOWORK=off.
+	Env []string
+	// Dir is the working directory of the build. To build from a local checkout with its own go.mod,
+	// set Dir to the checkout and the main path relative to it (e.g. ./cmd/apiserver).
+	Dir string
+	// Workspace are local module directories overlaid onto the module in Dir via an ephemeral go.work file.
+	// This allows building against local checkouts of dependencies without editing any go.mod.
+	Workspace []string
+}
+
+func (o *BuildOptions) ldflags() string {
+	ldflags := append([]string(nil), o.LDFlags...)
+
+	keys := make([]string, 0, len(o.VersionStamps))
+	for key := range o.VersionStamps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		ldflags = append(ldflags, fmt.Sprintf("-X '%s=%s'", key, o.VersionStamps[key]))
+	}
+	return strings.Join(ldflags, " ")
+}
+
+// BuildArgs returns the go build arguments to build mainPath into filename.
+func (o *BuildOptions) BuildArgs(mainPath, filename string) []string {
+	args := []string{"build", "-o", filename}
+	if len(o.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(o.Tags, ","))
+	}
+	if ldflags := o.ldflags(); ldflags != "" {
+		args = append(args, "-ldflags", ldflags)
+	}
+	if o.TrimPath {
+		args = append(args, "-trimpath")
+	}
+	if o.Mod != "" {
+		args = append(args, "-mod", string(o.Mod))
+	}
+	return append(args, mainPath)
+}
+
+// BuildEnv returns the environment variables set in addition to the current environment for building.
+func (o *BuildOptions) BuildEnv() []string {
+	env := append([]string(nil), o.Env...)
+	if len(o.GoFlags) > 0 {
+		env = append(env, fmt.Sprintf("GOFLAGS=%s", strings.Join(o.GoFlags, " ")))
+	}
+	return env
+}
+
+// withWorkspace returns the options with GOWORK pointing to an ephemeral go.work file using the module
+// in Dir and all Workspace modules. The returned function removes the go.work file again.
+func (o BuildOptions) withWorkspace() (BuildOptions, func(), error) {
+	if len(o.Workspace) == 0 {
+		return o, func() {}, nil
+	}
+
+	noWorkspace := o
+	noWorkspace.Env = append(append([]string(nil), o.Env...), "GOWORK=off")
+	out, err := goCommand(noWorkspace, "env", "GOVERSION", "GOMOD")
+	if err != nil {
+		return o, nil, fmt.Errorf("error determining main module: %w", err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return o, nil, fmt.Errorf("error determining go version")
+	}
+	goVersion := strings.TrimPrefix(fields[0], "go")
+
+	var modules []string
+	if len(fields) > 1 && fields[1] != os.DevNull {
+		modules = append(modules, filepath.Dir(fields[1]))
+	}
+	for _, dir := range o.Workspace {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return o, nil, fmt.Errorf("error determining absolute path of workspace module %s: %w", dir, err)
+		}
+		modules = append(modules, absDir)
+	}
+
+	var goWork bytes.Buffer
+	fmt.Fprintf(&goWork, "go %s\n\nuse (\n", goVersion)
+	for _, module := range modules {
+		fmt.Fprintf(&goWork, "\t%s\n", module)
+	}
+	goWork.WriteString(")\n")
+
+	dir, err := os.MkdirTemp("", "apiserver-workspace-")
+	if err != nil {
+		return o, nil, fmt.Errorf("error creating workspace directory: %w", err)
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+
+	goWorkPath := filepath.Join(dir, "go.work")
+	if err := os.WriteFile(goWorkPath, goWork.Bytes(), 0640); err != nil {
+		cleanup()
+		return o, nil, fmt.Errorf("error writing go.work: %w", err)
+	}
+	log.V(1).Info("Generated ephemeral go.work", "Path", goWorkPath, "Modules", modules)
+
+	res := o
+	res.Env = append(append([]string(nil), o.Env...), fmt.Sprintf("GOWORK=%s", goWorkPath))
+	res.Workspace = nil
+	return res, cleanup, nil
+}
+
+// Build builds the main package at mainPath into filename.
+func Build(mainPath, filename string, opts BuildOptions) error {
+	opts, cleanup, err := opts.withWorkspace()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	args := opts.BuildArgs(mainPath, filename)
+	env := opts.BuildEnv()
+
+	var buf bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.Dir = opts.Dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+
+	cmdString := strings.Join(append(append(env, "go"), args...), " ")
+	log.Info("Building api server", "Command", cmdString, "Dir", opts.Dir)
+	if err := cmd.Run(); err != nil {
+		err = fmt.Errorf("error running %s: %w", cmdString, err)
+		if output := buf.String(); output != "" {
+			return fmt.Errorf("%w, output:\n%s", err, output)
+		}
+		return err
+	}
+	return nil
+}
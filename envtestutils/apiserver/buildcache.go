@@ -0,0 +1,430 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package apiserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	buildCacheBinaryName   = "apiserver"
+	buildCacheMetadataName = "metadata.json"
+)
+
+// BuildCache is a local, content-addressed cache of built api server binaries.
+// Binaries are keyed by the main package, the hashes of all its dependencies,
+// the build options and the Go toolchain.
+type BuildCache struct {
+	// Dir is the root directory of the cache.
+	Dir string
+}
+
+// BuildCacheEntry is a binary stored in the BuildCache.
+type BuildCacheEntry struct {
+	// Key is the content hash identifying the entry.
+	Key string `json:"key"`
+	// MainPath is the package the binary was built from.
+	MainPath string `json:"mainPath"`
+	// BuildArgs are the go build arguments used to build the binary.
+	BuildArgs []string `json:"buildArgs"`
+	// GoVersion is the Go toolchain version used to build the binary.
+	GoVersion string `json:"goVersion"`
+	// Created is when the binary was built.
+	Created time.Time `json:"created"`
+	// LastUsed is when the binary was used last.
+	LastUsed time.Time `json:"-"`
+	// Size is the size of the binary in bytes.
+	Size int64 `json:"-"`
+}
+
+// DefaultBuildCacheDir returns the default directory of the BuildCache.
+func DefaultBuildCacheDir() (string, error) {
+	baseDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(baseDir, "openapi-extractor", "apiserver-builds"), nil
+}
+
+func (c *BuildCache) entryDir(key string) string {
+	return filepath.Join(c.Dir, key)
+}
+
+// listedPackage is the subset of `go list -json` output relevant for computing cache keys.
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	GoFiles    []string
+	CgoFiles   []string
+	CFiles     []string
+	CXXFiles   []string
+	HFiles     []string
+	SFiles     []string
+	SysoFiles  []string
+	EmbedFiles []string
+	Module     *listedModule
+}
+
+type listedModule struct {
+	Path    string
+	Version string
+	Sum     string
+	GoMod   string
+	Replace *listedModule
+}
+
+func goCommand(opts BuildOptions, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.Dir = opts.Dir
+	cmd.Env = append(os.Environ(), opts.BuildEnv()...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error running go %s: %w, output:\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+func goVersion(opts BuildOptions) (string, error) {
+	out, err := goCommand(opts, "env", "GOVERSION", "GOOS", "GOARCH", "CGO_ENABLED")
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(string(out)), " "), nil
+}
+
+// unhashedGoEnv are the go environment variables not affecting the built binary or differing between
+// invocations, e.g. GOGCCFLAGS containing a temporary directory.
+var unhashedGoEnv = sets.New(
+	"GOCACHE",
+	"GOENV",
+	"GOGCCFLAGS",
+	"GOTELEMETRY",
+	"GOTELEMETRYDIR",
+	"GOTMPDIR",
+)
+
+// goEnv returns the go environment of building with the given options.
+func goEnv(opts BuildOptions) (map[string]string, error) {
+	out, err := goCommand(opts, "env", "-json")
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]string)
+	if err := json.Unmarshal(out, &env); err != nil {
+		return nil, fmt.Errorf("error decoding go env: %w", err)
+	}
+	return env, nil
+}
+
+// Key computes the cache key of building mainPath with the given options.
+func (c *BuildCache) Key(mainPath string, opts BuildOptions) (string, error) {
+	h := sha256.New()
+
+	// The go environment covers the toolchain as well as ambient settings like GOFLAGS or CGO_CFLAGS.
+	env, err := goEnv(opts)
+	if err != nil {
+		return "", fmt.Errorf("error determining go environment: %w", err)
+	}
+	for _, key := range sets.List(sets.KeySet(env)) {
+		if !unhashedGoEnv.Has(key) {
+			_, _ = fmt.Fprintf(h, "goenv %s=%q\n", key, env[key])
+		}
+	}
+	_, _ = fmt.Fprintf(h, "args %q\n", opts.BuildArgs(mainPath, buildCacheBinaryName))
+	_, _ = fmt.Fprintf(h, "env %q\n", opts.BuildEnv())
+	_, _ = fmt.Fprintf(h, "dir %s\n", opts.Dir)
+	_, _ = fmt.Fprintf(h, "workspace %q\n", opts.Workspace)
+
+	// The packages of workspace modules are listed without version and thus hashed by their file contents.
+	opts, cleanup, err := opts.withWorkspace()
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	pkgs, err := listDependencies(mainPath, opts)
+	if err != nil {
+		return "", err
+	}
+
+	hashedGoMods := make(map[string]struct{})
+	for _, pkg := range pkgs {
+		if err := hashPackage(h, pkg, hashedGoMods); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// listDependencies lists mainPath and all packages it depends on.
+func listDependencies(mainPath string, opts BuildOptions) ([]*listedPackage, error) {
+	listArgs := []string{"list", "-deps", "-json"}
+	if len(opts.Tags) > 0 {
+		listArgs = append(listArgs, "-tags", strings.Join(opts.Tags, ","))
+	}
+	if opts.Mod != "" {
+		listArgs = append(listArgs, "-mod", string(opts.Mod))
+	}
+	out, err := goCommand(opts, append(listArgs, mainPath)...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing dependencies: %w", err)
+	}
+
+	var pkgs []*listedPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		pkg := &listedPackage{}
+		if err := dec.Decode(pkg); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("error decoding go list output: %w", err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// SourceDirs returns the directories of the packages mainPath is built from whose contents may change,
+// i.e. all packages except the standard library and packages of immutable module versions.
+func SourceDirs(mainPath string, opts BuildOptions) ([]string, error) {
+	opts, cleanup, err := opts.withWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	pkgs, err := listDependencies(mainPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, pkg := range pkgs {
+		if pkg.Standard || pkg.Dir == "" {
+			continue
+		}
+		mod := pkg.Module
+		if mod != nil && mod.Replace != nil {
+			mod = mod.Replace
+		}
+		if mod != nil && mod.Version != "" {
+			continue
+		}
+		dirs = append(dirs, pkg.Dir)
+	}
+	return dirs, nil
+}
+
+// hashPackage writes the identity of the package into h. Packages of immutable module versions
+// are identified by their module version, all other packages by the contents of their files.
+func hashPackage(h io.Writer, pkg *listedPackage, hashedGoMods map[string]struct{}) error {
+	_, _ = fmt.Fprintf(h, "package %s\n", pkg.ImportPath)
+	if pkg.Standard {
+		return nil
+	}
+
+	mod := pkg.Module
+	if mod != nil && mod.Replace != nil {
+		mod = mod.Replace
+	}
+	if mod != nil && mod.Version != "" {
+		_, _ = fmt.Fprintf(h, "module %s@%s %s\n", mod.Path, mod.Version, mod.Sum)
+		return nil
+	}
+
+	if mod != nil && mod.GoMod != "" {
+		if _, ok := hashedGoMods[mod.GoMod]; !ok {
+			hashedGoMods[mod.GoMod] = struct{}{}
+			if err := hashFile(h, mod.GoMod); err != nil {
+				return err
+			}
+			if err := hashFile(h, strings.TrimSuffix(mod.GoMod, ".mod")+".sum"); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	var files []string
+	for _, group := range [][]string{
+		pkg.GoFiles, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles, pkg.HFiles, pkg.SFiles, pkg.SysoFiles, pkg.EmbedFiles,
+	} {
+		files = append(files, group...)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if err := hashFile(h, filepath.Join(pkg.Dir, file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hashFile(h io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	_, _ = fmt.Fprintf(h, "file %s\n", path)
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("error hashing %s: %w", path, err)
+	}
+	_, _ = fmt.Fprintln(h)
+	return nil
+}
+
+// Build returns the path of the binary for mainPath built with opts. If the cache does
+// not contain a binary for the current sources yet, the binary is built and stored.
+func (c *BuildCache) Build(mainPath string, opts BuildOptions) (string, error) {
+	key, err := c.Key(mainPath, opts)
+	if err != nil {
+		return "", fmt.Errorf("error computing build cache key: %w", err)
+	}
+
+	dir := c.entryDir(key)
+	binary := filepath.Join(dir, buildCacheBinaryName)
+	if _, err := os.Stat(binary); err == nil {
+		log.Info("Using cached api server binary", "Key", key, "Binary", binary)
+		now := time.Now()
+		_ = os.Chtimes(dir, now, now)
+		return binary, nil
+	}
+
+	if err := os.MkdirAll(c.Dir, 0750); err != nil {
+		return "", fmt.Errorf("error creating build cache directory: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(c.Dir, "tmp-")
+	if err != nil {
+		return "", fmt.Errorf("error creating temporary build directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	tmpBinary, err := filepath.Abs(filepath.Join(tmpDir, buildCacheBinaryName))
+	if err != nil {
+		return "", err
+	}
+	if err := Build(mainPath, tmpBinary, opts); err != nil {
+		return "", err
+	}
+
+	version, err := goVersion(opts)
+	if err != nil {
+		return "", fmt.Errorf("error determining go version: %w", err)
+	}
+	metadata, err := json.Marshal(&BuildCacheEntry{
+		Key:       key,
+		MainPath:  mainPath,
+		BuildArgs: opts.BuildArgs(mainPath, buildCacheBinaryName),
+		GoVersion: version,
+		Created:   time.Now(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error marshalling build cache metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, buildCacheMetadataName), metadata, 0640); err != nil {
+		return "", fmt.Errorf("error writing build cache metadata: %w", err)
+	}
+
+	if err := os.Rename(tmpDir, dir); err != nil {
+		// Another process may have stored the same binary concurrently.
+		if _, statErr := os.Stat(binary); statErr == nil {
+			return binary, nil
+		}
+		return "", fmt.Errorf("error storing api server binary in build cache: %w", err)
+	}
+	log.Info("Stored api server binary in build cache", "Key", key, "Binary", binary)
+	return binary, nil
+}
+
+// List lists all entries of the cache, most recently used first.
+func (c *BuildCache) List() ([]BuildCacheEntry, error) {
+	dirEntries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading build cache directory: %w", err)
+	}
+
+	var entries []BuildCacheEntry
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() || strings.HasPrefix(dirEntry.Name(), "tmp-") {
+			continue
+		}
+
+		dir := c.entryDir(dirEntry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, buildCacheMetadataName))
+		if err != nil {
+			continue
+		}
+		entry := BuildCacheEntry{}
+		if err := json.Unmarshal(data, &entry); err != nil {
+			continue
+		}
+		// The directory name is authoritative, the metadata is not trusted to address the entry.
+		entry.Key = dirEntry.Name()
+
+		if info, err := os.Stat(dir); err == nil {
+			entry.LastUsed = info.ModTime()
+		}
+		if info, err := os.Stat(filepath.Join(dir, buildCacheBinaryName)); err == nil {
+			entry.Size = info.Size()
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// Prune removes all entries that were not used within maxAge as well as leftovers of
+// interrupted builds. A maxAge of zero removes all entries.
+func (c *BuildCache) Prune(maxAge time.Duration) ([]BuildCacheEntry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []BuildCacheEntry
+	for _, entry := range entries {
+		if maxAge > 0 && time.Since(entry.LastUsed) < maxAge {
+			continue
+		}
+		if err := os.RemoveAll(c.entryDir(entry.Key)); err != nil {
+			return pruned, fmt.Errorf("error removing build cache entry %s: %w", entry.Key, err)
+		}
+		pruned = append(pruned, entry)
+	}
+
+	tmpDirs, err := filepath.Glob(filepath.Join(c.Dir, "tmp-*"))
+	if err != nil {
+		return pruned, err
+	}
+	for _, tmpDir := range tmpDirs {
+		if info, err := os.Stat(tmpDir); err == nil && time.Since(info.ModTime()) > time.Hour {
+			_ = os.RemoveAll(tmpDir)
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package apiserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	defaultHealthProbePath = "/readyz"
+
+	healthProbeURLEnv = "APISERVER_URL"
+)
+
+// HealthProbe configures how the api server is probed until it is healthy.
+type HealthProbe struct {
+	// Paths are HTTP paths that all have to respond with 200 OK, e.g. /livez, /readyz?exclude=etcd or
+	// a custom endpoint. Defaults to /readyz unless Exec is set.
+	Paths []string
+	// Exec is a command that has to exit successfully. The URL of the api server is passed to it via the
+	// APISERVER_URL environment variable.
+	Exec []string
+	// CAData is the PEM encoded CA to verify the serving certificate of the api server with.
+	// If empty, the serving certificate is not verified.
+	CAData []byte
+	// ClientCertFile and ClientKeyFile are an optional client certificate to authenticate the probes with.
+	ClientCertFile string
+	ClientKeyFile  string
+}
+
+func (p *HealthProbe) paths() []string {
+	if len(p.Paths) == 0 && len(p.Exec) == 0 {
+		return []string{defaultHealthProbePath}
+	}
+	return p.Paths
+}
+
+func (p *HealthProbe) httpClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if len(p.CAData) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(p.CAData) {
+			return nil, fmt.Errorf("error parsing health probe CA data")
+		}
+		tlsConfig.RootCAs = pool
+	} else {
+		tlsConfig.InsecureSkipVerify = true // skip verify for doing local health checks is ok.
+	}
+	if p.ClientCertFile != "" || p.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.ClientCertFile, p.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading health probe client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+func (a *APIServer) pollHealthCheck(ctx context.Context) error {
+	httpClient, err := a.healthProbe.httpClient()
+	if err != nil {
+		return err
+	}
+
+	var lastFailure string
+	if err := wait.PollUntilContextCancel(ctx, 1*time.Second, true, func(ctx context.Context) (done bool, err error) {
+		failure := a.probe(ctx, httpClient)
+		if failure != "" && failure != lastFailure {
+			log.V(1).Info("Api server is not healthy yet", "Reason", failure)
+		}
+		lastFailure = failure
+		return failure == "", nil
+	}); err != nil {
+		if lastFailure != "" {
+			return fmt.Errorf("%w, last probe failure: %s", err, lastFailure)
+		}
+		return err
+	}
+	return nil
+}
+
+// probe runs all probes once and describes the first failing one. If all probes succeed, it returns an empty string.
+func (a *APIServer) probe(ctx context.Context, httpClient *http.Client) string {
+	baseURL := fmt.Sprintf("https://%s:%d", a.host, a.port)
+	for _, probePath := range a.healthProbe.paths() {
+		if failure := probeHTTP(ctx, httpClient, baseURL, probePath); failure != "" {
+			return failure
+		}
+	}
+
+	if exe := a.healthProbe.Exec; len(exe) > 0 {
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, exe[0], exe[1:]...)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", healthProbeURLEnv, baseURL))
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		if err := cmd.Run(); err != nil {
+			return fmt.Sprintf("exec probe %v: %v: %s", exe, err, strings.TrimSpace(out.String()))
+		}
+	}
+	return ""
+}
+
+func probeHTTP(ctx context.Context, httpClient *http.Client, baseURL, probePath string) string {
+	u, err := url.Parse(baseURL + probePath)
+	if err != nil {
+		return fmt.Sprintf("invalid probe path %s: %v", probePath, err)
+	}
+	// Request verbose output of the Kubernetes health endpoints to be able to report the failing checks.
+	switch path.Base(u.Path) {
+	case "readyz", "livez", "healthz":
+		if q := u.Query(); !q.Has("verbose") {
+			q.Set("verbose", "")
+			u.RawQuery = q.Encode()
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Sprintf("error creating health request: %v", err)
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Sprintf("GET %s: %v", probePath, err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode == http.StatusOK {
+		return ""
+	}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if checks := failingChecks(body); len(checks) > 0 {
+		return fmt.Sprintf("GET %s: %s, failing checks: %s", probePath, res.Status, strings.Join(checks, ", "))
+	}
+	return fmt.Sprintf("GET %s: %s", probePath, res.Status)
+}
+
+// failingChecks parses the verbose output of a Kubernetes health endpoint, e.g.
+//
+//	[+]ping ok
+//	[-]poststarthook/start-informers failed: reason withheld
+//
+// and returns the names of the failing checks.
+func failingChecks(body []byte) []string {
+	var checks []string
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "[-]") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(line, "[-]"), " ")
+		checks = append(checks, name)
+	}
+	return checks
+}
@@ -0,0 +1,28 @@
+//go:build !unix
+
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package apiserver
+
+import (
+	"os"
+	"syscall"
+)
+
+func sysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// signalProcessGroup signals p. Process groups are not supported on this platform.
+func signalProcessGroup(p *os.Process, sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		return p.Kill()
+	}
+	return p.Signal(sig)
+}
+
+// processGroupAlive reports whether p is still running. This cannot be determined on this platform.
+func processGroupAlive(*os.Process) bool {
+	return false
+}
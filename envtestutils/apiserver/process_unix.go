@@ -0,0 +1,25 @@
+//go:build unix
+
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package apiserver
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// signalProcessGroup sends sig to the process group of p, which includes all processes forked by it.
+func signalProcessGroup(p *os.Process, sig syscall.Signal) error {
+	if err := syscall.Kill(-p.Pid, sig); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	return nil
+}
+
+// processGroupAlive reports whether any process of the process group of p is still running.
+func processGroupAlive(p *os.Process) bool {
+	return syscall.Kill(-p.Pid, 0) == nil
+}
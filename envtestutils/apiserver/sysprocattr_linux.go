@@ -0,0 +1,20 @@
+//go:build linux
+
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package apiserver
+
+import (
+	"syscall"
+)
+
+// sysProcAttr starts the api server in its own process group and asks the kernel to kill it if the parent dies.
+// The kernel sends the signal once the OS thread that started the api server exits (see golang/go#27505), so
+// the api server has to be started from a goroutine locked to its thread until the api server exited.
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/sets"
 	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
@@ -34,17 +35,49 @@ func readAPIServiceFiles(options *APIServiceInstallOptions) error {
 			return err
 		}
 
+		options.pathAPIServices = copyAPIServices(apiServiceList)
 		options.APIServices = append(options.APIServices, apiServiceList...)
 	}
 	return nil
 }
 
+// ReadAPIServices reads the APIServices defined in the given files and directories.
+func ReadAPIServices(paths []string, errorIfPathMissing bool) ([]*apiregistrationv1.APIService, error) {
+	return renderAPIServices(&APIServiceInstallOptions{Paths: paths, ErrorIfPathMissing: errorIfPathMissing})
+}
+
+func copyAPIServices(apiServices []*apiregistrationv1.APIService) []*apiregistrationv1.APIService {
+	res := make([]*apiregistrationv1.APIService, 0, len(apiServices))
+	for _, apiService := range apiServices {
+		res = append(res, apiService.DeepCopy())
+	}
+	return res
+}
+
+// apiServicesEqual reports whether both lists define the same APIServices, independent of their order.
+func apiServicesEqual(a, b []*apiregistrationv1.APIService) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byName := make(map[string]*apiregistrationv1.APIService, len(a))
+	for _, apiService := range a {
+		byName[apiService.Name] = apiService
+	}
+	for _, apiService := range b {
+		other, ok := byName[apiService.Name]
+		if !ok || !equality.Semantic.DeepEqual(other.Spec, apiService.Spec) {
+			return false
+		}
+	}
+	return true
+}
+
 // renderAPIServices iterate through options.Paths and extract all APIService files.
+// Directories containing a kustomization are built and the APIServices of the output are used.
 func renderAPIServices(options *APIServiceInstallOptions) ([]*apiregistrationv1.APIService, error) {
 	var (
-		err   error
-		info  os.FileInfo
-		files []os.FileInfo
+		err  error
+		info os.FileInfo
 	)
 
 	type GVKN struct {
@@ -65,9 +98,19 @@ func renderAPIServices(options *APIServiceInstallOptions) ([]*apiregistrationv1.
 			continue
 		}
 
-		if !info.IsDir() {
+		var (
+			files          []os.FileInfo
+			apiServiceList []*apiregistrationv1.APIService
+		)
+		switch {
+		case !info.IsDir():
 			filePath, files = filepath.Dir(path), []os.FileInfo{info}
-		} else {
+		case IsKustomization(path):
+			log.V(1).Info("rendering APIServices from kustomization", "path", path)
+			if apiServiceList, err = renderKustomization(path); err != nil {
+				return nil, err
+			}
+		default:
 			entries, err := os.ReadDir(path)
 			if err != nil {
 				return nil, err
@@ -83,10 +126,11 @@ func renderAPIServices(options *APIServiceInstallOptions) ([]*apiregistrationv1.
 			}
 		}
 
-		log.V(1).Info("reading APIServices from path", "path", path)
-		apiServiceList, err := readAPIServices(filePath, files)
-		if err != nil {
-			return nil, err
+		if len(files) > 0 {
+			log.V(1).Info("reading APIServices from path", "path", path)
+			if apiServiceList, err = readAPIServices(filePath, files); err != nil {
+				return nil, err
+			}
 		}
 
 		for i, apiService := range apiServiceList {
@@ -128,15 +172,13 @@ func readAPIServices(basePath string, files []os.FileInfo) ([]*apiregistrationv1
 		}
 
 		for _, doc := range docs {
-			apiService := &apiregistrationv1.APIService{}
-			if err = yaml.Unmarshal(doc, apiService); err != nil {
+			apiService, err := decodeAPIService(doc)
+			if err != nil {
 				return nil, err
 			}
-
-			if apiService.Kind != "APIService" || apiService.Spec.Group == "" || apiService.Spec.Version == "" {
-				continue
+			if apiService != nil {
+				apiServices = append(apiServices, apiService)
 			}
-			apiServices = append(apiServices, apiService)
 		}
 
 		log.V(1).Info("read APIServices from file", "file", file.Name())
@@ -144,6 +186,19 @@ func readAPIServices(basePath string, files []os.FileInfo) ([]*apiregistrationv1
 	return apiServices, nil
 }
 
+// decodeAPIService decodes a YAML or JSON document. It returns nil if the document is no APIService.
+func decodeAPIService(doc []byte) (*apiregistrationv1.APIService, error) {
+	apiService := &apiregistrationv1.APIService{}
+	if err := yaml.Unmarshal(doc, apiService); err != nil {
+		return nil, err
+	}
+
+	if apiService.Kind != "APIService" || apiService.Spec.Group == "" || apiService.Spec.Version == "" {
+		return nil, nil
+	}
+	return apiService, nil
+}
+
 // readDocuments reads documents from file.
 func readDocuments(fp string) ([][]byte, error) {
 	b, err := os.ReadFile(fp) //nolint:gosec
@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package envtestutils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func init() {
+	_ = apiextensionsv1.AddToScheme(apiServiceScheme)
+}
+
+// CRDs returns the CRDs installed via the CRDInstallOptions of the environment started with StartWithExtensions.
+func (e *EnvironmentExtensions) CRDs() []*apiextensionsv1.CustomResourceDefinition {
+	return e.crds
+}
+
+func WaitUntilCRDsEstablished(ctx context.Context, c client.Client, crds ...*apiextensionsv1.CustomResourceDefinition) error {
+	pending := make([]*apiextensionsv1.CustomResourceDefinition, 0, len(crds))
+	for _, crd := range crds {
+		pending = append(pending, crd.DeepCopy())
+	}
+
+	if err := wait.PollUntilContextCancel(ctx, 50*time.Millisecond, true, func(ctx context.Context) (done bool, err error) {
+		for i := len(pending) - 1; i >= 0; i-- {
+			crd := pending[i]
+			if err := c.Get(ctx, client.ObjectKeyFromObject(crd), crd); err != nil {
+				return false, fmt.Errorf("error getting crd %s: %w", crd.Name, err)
+			}
+
+			if crdEstablished(crd) {
+				pending = append(pending[:i], pending[i+1:]...)
+			}
+		}
+		return len(pending) == 0, nil
+	}); err != nil {
+		if len(pending) == 0 {
+			return err
+		}
+		unestablishedCRDs := make([]string, 0, len(pending))
+		for _, crd := range pending {
+			unestablishedCRDs = append(unestablishedCRDs, crd.Name)
+		}
+		sort.Strings(unestablishedCRDs)
+		return fmt.Errorf("%w, crds not established: %v", err, unestablishedCRDs)
+	}
+	return nil
+}
+
+func crdEstablished(crd *apiextensionsv1.CustomResourceDefinition) bool {
+	for _, cond := range crd.Status.Conditions {
+		if cond.Type == apiextensionsv1.Established {
+			return cond.Status == apiextensionsv1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -5,7 +5,9 @@ package envtestutils
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -14,13 +16,16 @@ import (
 	"time"
 
 	"github.com/ironcore-dev/controller-utils/conditionutils"
+	"github.com/ironcore-dev/openapi-extractor/envtestutils/logfile"
 	"github.com/ironcore-dev/openapi-extractor/internal/testing/addr"
 	"github.com/ironcore-dev/openapi-extractor/internal/testing/certs"
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
@@ -56,6 +61,13 @@ type APIServiceInstallOptions struct {
 
 	ServiceNamespace string
 	ServiceName      string
+
+	// pathAPIServices are the APIServices read from Paths as defined in the files.
+	pathAPIServices []*apiregistrationv1.APIService
+
+	// tempDirs and ports are the temporary directories created and the ports reserved, released by Stop.
+	tempDirs []string
+	ports    []int
 }
 
 func (o *APIServiceInstallOptions) tlsCertPath() string {
@@ -78,18 +90,38 @@ func (o *APIServiceInstallOptions) clientKeyPath() string {
 	return filepath.Join(o.ClientCertDir, "client.key")
 }
 
+// ClientCertPaths returns the paths of the client certificate and key the kube-apiserver uses to
+// authenticate against the aggregated api servers. They are only available after SetupClientCA.
+func (o *APIServiceInstallOptions) ClientCertPaths() (certFile, keyFile string) {
+	return o.clientCertPath(), o.clientKeyPath()
+}
+
 func (o *APIServiceInstallOptions) clientCACertPath() string {
 	return filepath.Join(o.ClientCertDir, "client-ca.crt")
 }
 
+// serviceKey returns the namespace and name of the service the APIServices are pointed at.
+func (o *APIServiceInstallOptions) serviceKey() (namespace, name string) {
+	namespace = o.ServiceNamespace
+	if namespace == "" {
+		namespace = metav1.NamespaceSystem
+	}
+	name = o.ServiceName
+	if name == "" {
+		name = "aggregated-apiserver"
+	}
+	return namespace, name
+}
+
 func (o *APIServiceInstallOptions) setupCA() error {
 	apiServiceCA, err := certs.NewTinyCA()
 	if err != nil {
 		return fmt.Errorf("unable to set up api service CA: %v", err)
 	}
 
+	svcNamespace, svcName := o.serviceKey()
 	names := []string{"localhost", o.LocalServingHost, o.LocalServingHostExternalName}
-	apiServiceCert, err := apiServiceCA.NewServingCert(names, []string{"aggregated-apiserver.kube-system.svc"})
+	apiServiceCert, err := apiServiceCA.NewServingCert(names, []string{fmt.Sprintf("%s.%s.svc", svcName, svcNamespace)})
 	if err != nil {
 		return fmt.Errorf("unable to set up api service serving certs: %v", err)
 	}
@@ -99,6 +131,7 @@ func (o *APIServiceInstallOptions) setupCA() error {
 	if err != nil {
 		return fmt.Errorf("unable to create directory for apiservice serving certs: %v", err)
 	}
+	o.tempDirs = append(o.tempDirs, localServingCertsDir)
 
 	certData, keyData, err := apiServiceCert.AsBytes()
 	if err != nil {
@@ -125,6 +158,7 @@ func (o *APIServiceInstallOptions) generateHostPort() (string, int, error) {
 		if err != nil {
 			return "", 0, fmt.Errorf("unable to grab random port for serving api services on: %v", err)
 		}
+		o.ports = append(o.ports, port)
 		o.LocalServingPort = port
 		o.LocalServingHost = host
 	}
@@ -142,20 +176,12 @@ func (o *APIServiceInstallOptions) generateService(cfg *rest.Config) (namespace,
 		return "", "", fmt.Errorf("error creating client: %w", err)
 	}
 
-	namespace = o.ServiceNamespace
-	if namespace == "" {
-		namespace = metav1.NamespaceSystem
-	}
-
 	host, _, err := o.generateHostPort()
 	if err != nil {
 		return "", "", fmt.Errorf("error generating host port: %w", err)
 	}
 
-	name = o.ServiceName
-	if name == "" {
-		name = "aggregated-apiserver"
-	}
+	namespace, name = o.serviceKey()
 
 	service := &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
@@ -206,6 +232,26 @@ func (o *APIServiceInstallOptions) ModifyAPIServiceDefinitions(cfg *rest.Config)
 	return nil
 }
 
+// Reallocate picks a fresh port via addr.Suggest and points the service and the APIServices to it.
+// It is used to restart an aggregated api server after its port was taken by another process.
+func (o *APIServiceInstallOptions) Reallocate(cfg *rest.Config) error {
+	port, host, err := addr.Suggest(o.LocalServingHost)
+	if err != nil {
+		return fmt.Errorf("unable to grab random port for serving api services on: %v", err)
+	}
+	o.ports = append(o.ports, port)
+	o.LocalServingPort = port
+	o.LocalServingHost = host
+
+	if err := o.ModifyAPIServiceDefinitions(cfg); err != nil {
+		return fmt.Errorf("error modifying api service definitions: %w", err)
+	}
+	if err := o.ApplyAPIServices(cfg); err != nil {
+		return fmt.Errorf("error applying api services: %w", err)
+	}
+	return nil
+}
+
 func (o *APIServiceInstallOptions) SetupClientCA() error {
 	clientCA, err := certs.NewTinyCA()
 	if err != nil {
@@ -225,6 +271,7 @@ func (o *APIServiceInstallOptions) SetupClientCA() error {
 	if err != nil {
 		return fmt.Errorf("unable to create directory for apiserver client certs: %v", err)
 	}
+	o.tempDirs = append(o.tempDirs, clientCertDir)
 
 	certData, keyData, err := clientCert.AsBytes()
 	if err != nil {
@@ -246,6 +293,11 @@ func (o *APIServiceInstallOptions) SetupClientCA() error {
 }
 
 func (o *APIServiceInstallOptions) PrepWithoutInstalling(cfg *rest.Config) error {
+	// Determine the serving host first so the serving certificate is valid for it.
+	if _, _, err := o.generateHostPort(); err != nil {
+		return fmt.Errorf("error generating host port: %w", err)
+	}
+
 	if err := o.setupCA(); err != nil {
 		return fmt.Errorf("error setting up ca: %w", err)
 	}
@@ -283,7 +335,98 @@ func (o *APIServiceInstallOptions) Install(cfg *rest.Config) error {
 	return nil
 }
 
+// Stop removes the temporary directories created for the certificates and releases the reserved ports.
 func (o *APIServiceInstallOptions) Stop() error {
+	var errs []error
+	for _, dir := range o.tempDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, fmt.Errorf("error removing %s: %w", dir, err))
+		}
+	}
+	for _, port := range o.ports {
+		if err := addr.Release(port); err != nil {
+			errs = append(errs, fmt.Errorf("error releasing port %d: %w", port, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReloadAPIServices reads the APIServices from Paths again. If their definitions changed, the
+// APIServices are applied again and the ones no longer defined are deleted. It reports whether
+// the APIServices changed.
+func (o *APIServiceInstallOptions) ReloadAPIServices(cfg *rest.Config) (bool, error) {
+	if len(o.Paths) == 0 {
+		return false, nil
+	}
+	apiServices, err := renderAPIServices(o)
+	if err != nil {
+		return false, fmt.Errorf("error reading api services: %w", err)
+	}
+	if apiServicesEqual(o.pathAPIServices, apiServices) {
+		return false, nil
+	}
+
+	oldNames := sets.New[string]()
+	for _, apiService := range o.pathAPIServices {
+		oldNames.Insert(apiService.Name)
+	}
+	newNames := sets.New[string]()
+	for _, apiService := range apiServices {
+		newNames.Insert(apiService.Name)
+	}
+
+	var kept []*apiregistrationv1.APIService
+	for _, apiService := range o.APIServices {
+		if !oldNames.Has(apiService.Name) {
+			kept = append(kept, apiService)
+		}
+	}
+	o.pathAPIServices = copyAPIServices(apiServices)
+	o.APIServices = append(kept, apiServices...)
+
+	if err := o.ModifyAPIServiceDefinitions(cfg); err != nil {
+		return false, fmt.Errorf("error modifying api service definitions: %w", err)
+	}
+	if err := o.ApplyAPIServices(cfg); err != nil {
+		return false, fmt.Errorf("error applying api services: %w", err)
+	}
+
+	c, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return false, fmt.Errorf("error creating client: %w", err)
+	}
+	for _, name := range sets.List(oldNames.Difference(newNames)) {
+		if err := c.Delete(context.TODO(), &apiregistrationv1.APIService{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+		}); client.IgnoreNotFound(err) != nil {
+			return false, fmt.Errorf("error deleting api service %s: %w", name, err)
+		}
+	}
+	return true, nil
+}
+
+// Uninstall deletes the APIServices and the service applied by Install.
+func (o *APIServiceInstallOptions) Uninstall(cfg *rest.Config) error {
+	ctx := context.TODO()
+	c, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return fmt.Errorf("error creating client: %w", err)
+	}
+
+	for _, apiService := range o.APIServices {
+		if err := c.Delete(ctx, &apiregistrationv1.APIService{
+			ObjectMeta: metav1.ObjectMeta{Name: apiService.Name},
+		}); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("error deleting api service %s: %w", apiService.Name, err)
+		}
+	}
+
+	svcNamespace, svcName := o.serviceKey()
+	if err := c.Delete(ctx, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: svcNamespace, Name: svcName},
+	}); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("error deleting service %s/%s: %w", svcNamespace, svcName, err)
+	}
 	return nil
 }
 
@@ -317,12 +460,81 @@ type EnvironmentExtensions struct {
 	APIServiceInstallOptions APIServiceInstallOptions
 	APIServices              []*apiregistrationv1.APIService
 
+	// AdditionalAPIServiceInstallOptions install the APIServices of further aggregated api servers
+	// into the same control plane. Each aggregated api server gets its own serving certificates,
+	// port and service and thus has to use a distinct ServiceName.
+	AdditionalAPIServiceInstallOptions []APIServiceInstallOptions
+
 	// APIServiceDirectoryPaths is a list of paths containing APIService yaml or json configs.
 	// If both this field and Paths field in APIServiceInstallOptions are specified, the
 	// values are merged.
 	APIServiceDirectoryPaths []string
 
 	ErrorIfAPIServicePathIsMissing bool
+
+	// UninstallOnStop deletes the APIServices and services of all aggregated api servers when stopping.
+	// This is only useful with an existing cluster, a local control plane is discarded anyway.
+	UninstallOnStop bool
+
+	// LogDir is the directory the output of the control plane (etcd, kube-apiserver) is captured in.
+	// If empty, the output is not captured.
+	LogDir     string
+	LogOptions logfile.Options
+
+	logFiles   []*logfile.File
+	logWriters []io.WriteCloser
+
+	controlPlane controlPlaneResources
+
+	crds []*apiextensionsv1.CustomResourceDefinition
+}
+
+// LogFiles returns the files the output of the control plane is captured in.
+func (e *EnvironmentExtensions) LogFiles() []*logfile.File {
+	return e.logFiles
+}
+
+// captureControlPlaneOutput captures the output of etcd and the kube-apiserver into log files.
+// If the environment attaches the control plane output, it is additionally written to stdout / stderr.
+func captureControlPlaneOutput(env *envtest.Environment, ext *EnvironmentExtensions) error {
+	if env.ControlPlane.Etcd == nil {
+		env.ControlPlane.Etcd = &envtest.Etcd{}
+	}
+	attach := env.AttachControlPlaneOutput || os.Getenv("KUBEBUILDER_ATTACH_CONTROL_PLANE_OUTPUT") == "true"
+
+	apiServer := env.ControlPlane.GetAPIServer()
+	for _, component := range []struct {
+		name     string
+		out, err *io.Writer
+	}{
+		{name: "etcd", out: &env.ControlPlane.Etcd.Out, err: &env.ControlPlane.Etcd.Err},
+		{name: "kube-apiserver", out: &apiServer.Out, err: &apiServer.Err},
+	} {
+		file, err := logfile.Open(ext.LogDir, component.name, ext.LogOptions)
+		if err != nil {
+			return fmt.Errorf("error opening %s log file: %w", component.name, err)
+		}
+		ext.logFiles = append(ext.logFiles, file)
+
+		stdout, stderr := file.Writer(), file.Writer()
+		ext.logWriters = append(ext.logWriters, stdout, stderr)
+		*component.out, *component.err = stdout, stderr
+		if attach {
+			*component.out = io.MultiWriter(os.Stdout, stdout)
+			*component.err = io.MultiWriter(os.Stderr, stderr)
+		}
+	}
+	return nil
+}
+
+func closeControlPlaneLogs(ext *EnvironmentExtensions) {
+	for _, w := range ext.logWriters {
+		_ = w.Close()
+	}
+	ext.logWriters = nil
+	for _, file := range ext.logFiles {
+		_ = file.Close()
+	}
 }
 
 func envUsesExistingCluster(env *envtest.Environment) bool {
@@ -343,6 +555,67 @@ func configureAPIServerAggregation(env *envtest.Environment, ext *EnvironmentExt
 		Set("requestheader-username-headers", "X-Remote-User")
 }
 
+// AllAPIServiceInstallOptions returns the install options of all aggregated api servers, starting
+// with APIServiceInstallOptions followed by AdditionalAPIServiceInstallOptions.
+func (e *EnvironmentExtensions) AllAPIServiceInstallOptions() []*APIServiceInstallOptions {
+	res := []*APIServiceInstallOptions{&e.APIServiceInstallOptions}
+	for i := range e.AdditionalAPIServiceInstallOptions {
+		res = append(res, &e.AdditionalAPIServiceInstallOptions[i])
+	}
+	return res
+}
+
+// AllAPIServices returns the APIServices of all aggregated api servers.
+func (e *EnvironmentExtensions) AllAPIServices() []*apiregistrationv1.APIService {
+	var res []*apiregistrationv1.APIService
+	for _, opts := range e.AllAPIServiceInstallOptions() {
+		res = append(res, opts.APIServices...)
+	}
+	return res
+}
+
+// installAPIServices installs the APIServices of all aggregated api servers. No APIService may be
+// served by more than one aggregated api server.
+func installAPIServices(cfg *rest.Config, ext *EnvironmentExtensions) error {
+	allOpts := ext.AllAPIServiceInstallOptions()
+	if len(allOpts) == 1 {
+		return ext.APIServiceInstallOptions.Install(cfg)
+	}
+
+	services := sets.New[string]()
+	apiServices := make(map[string]string)
+	for _, opts := range allOpts {
+		svcNamespace, svcName := opts.serviceKey()
+		svcKey := fmt.Sprintf("%s/%s", svcNamespace, svcName)
+		if services.Has(svcKey) {
+			return fmt.Errorf("multiple aggregated api servers use service %s", svcKey)
+		}
+		services.Insert(svcKey)
+
+		if err := readAPIServiceFiles(opts); err != nil {
+			return fmt.Errorf("error reading api services: %w", err)
+		}
+		for _, apiService := range opts.APIServices {
+			if other, ok := apiServices[apiService.Name]; ok {
+				return fmt.Errorf("api service %s is served by both service %s and %s", apiService.Name, other, svcKey)
+			}
+			apiServices[apiService.Name] = svcKey
+		}
+	}
+
+	for _, opts := range allOpts {
+		svcNamespace, svcName := opts.serviceKey()
+		if err := opts.PrepWithoutInstalling(cfg); err != nil {
+			return fmt.Errorf("[aggregated api server %s/%s] error preparing: %w", svcNamespace, svcName, err)
+		}
+
+		if err := opts.ApplyAPIServices(cfg); err != nil {
+			return fmt.Errorf("[aggregated api server %s/%s] error installing: %w", svcNamespace, svcName, err)
+		}
+	}
+	return nil
+}
+
 func StartWithExtensions(env *envtest.Environment, ext *EnvironmentExtensions) (*rest.Config, error) {
 	ext.APIServiceInstallOptions.APIServices = mergeAPIServices(ext.APIServiceInstallOptions.APIServices, ext.APIServices)
 	ext.APIServiceInstallOptions.Paths = mergePaths(ext.APIServiceInstallOptions.Paths, ext.APIServiceDirectoryPaths)
@@ -352,17 +625,37 @@ func StartWithExtensions(env *envtest.Environment, ext *EnvironmentExtensions) (
 		return nil, fmt.Errorf("error setting up client ca: %w", err)
 	}
 
+	// The kube-apiserver uses a single proxy client certificate for all aggregated api servers.
+	for i := range ext.AdditionalAPIServiceInstallOptions {
+		opts := &ext.AdditionalAPIServiceInstallOptions[i]
+		opts.ErrorIfPathMissing = ext.ErrorIfAPIServicePathIsMissing
+		opts.ClientCertDir = ext.APIServiceInstallOptions.ClientCertDir
+		opts.ClientCAData = ext.APIServiceInstallOptions.ClientCAData
+	}
+
 	if !envUsesExistingCluster(env) {
 		configureAPIServerAggregation(env, ext)
+
+		if ext.LogDir != "" {
+			if err := captureControlPlaneOutput(env, ext); err != nil {
+				closeControlPlaneLogs(ext)
+				return nil, err
+			}
+		}
 	}
 
+	controlPlane := newControlPlaneResources(env)
 	cfg, err := env.Start()
 	if err != nil {
+		closeControlPlaneLogs(ext)
 		return nil, err
 	}
+	controlPlane.record(env)
+	ext.controlPlane = controlPlane
+	ext.crds = env.CRDs
 
-	if err := ext.APIServiceInstallOptions.Install(cfg); err != nil {
-		if err := env.Stop(); err != nil {
+	if err := installAPIServices(cfg, ext); err != nil {
+		if err := StopWithExtensions(env, ext); err != nil {
 			log.Error(err, "Error stopping test-env")
 		}
 		return nil, err
@@ -371,16 +664,69 @@ func StartWithExtensions(env *envtest.Environment, ext *EnvironmentExtensions) (
 	return cfg, nil
 }
 
+// InstallWithExtensions installs the aggregated api servers of ext into an environment started with
+// StartWithExtensions(env, base). They use the proxy client certificate of base, so that several sets of
+// aggregated api servers can be installed into and uninstalled from the same environment independently.
+// Only the APIService and Service fields of ext are used.
+func InstallWithExtensions(cfg *rest.Config, base, ext *EnvironmentExtensions) error {
+	ext.APIServiceInstallOptions.APIServices = mergeAPIServices(ext.APIServiceInstallOptions.APIServices, ext.APIServices)
+	ext.APIServiceInstallOptions.Paths = mergePaths(ext.APIServiceInstallOptions.Paths, ext.APIServiceDirectoryPaths)
+	for _, opts := range ext.AllAPIServiceInstallOptions() {
+		opts.ErrorIfPathMissing = ext.ErrorIfAPIServicePathIsMissing
+		opts.ClientCertDir = base.APIServiceInstallOptions.ClientCertDir
+		opts.ClientCAData = base.APIServiceInstallOptions.ClientCAData
+	}
+
+	if err := installAPIServices(cfg, ext); err != nil {
+		if err := UninstallWithExtensions(cfg, ext); err != nil {
+			log.Error(err, "Error uninstalling aggregated api servers")
+		}
+		return err
+	}
+	return nil
+}
+
+// UninstallWithExtensions deletes the APIServices and services of the aggregated api servers installed by
+// InstallWithExtensions and removes everything created for them. All steps are attempted even if some of them fail.
+func UninstallWithExtensions(cfg *rest.Config, ext *EnvironmentExtensions) error {
+	var errs []error
+	for _, opts := range ext.AllAPIServiceInstallOptions() {
+		if err := opts.Uninstall(cfg); err != nil {
+			errs = append(errs, fmt.Errorf("error uninstalling aggregated api server: %w", err))
+		}
+		if err := opts.Stop(); err != nil {
+			errs = append(errs, fmt.Errorf("error stopping aggregated api server: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StopWithExtensions stops the environment and removes everything created for the extensions.
+// All steps are attempted even if some of them fail.
 func StopWithExtensions(env *envtest.Environment, ext *EnvironmentExtensions) error {
-	if err := ext.APIServiceInstallOptions.Stop(); err != nil {
-		return fmt.Errorf("error stopping aggregated api server: %w", err)
+	defer closeControlPlaneLogs(ext)
+
+	var errs []error
+	if ext.UninstallOnStop && env.Config != nil {
+		for _, opts := range ext.AllAPIServiceInstallOptions() {
+			if err := opts.Uninstall(env.Config); err != nil {
+				errs = append(errs, fmt.Errorf("error uninstalling aggregated api server: %w", err))
+			}
+		}
 	}
 
 	if err := env.Stop(); err != nil {
-		return fmt.Errorf("error stopping environment: %w", err)
+		errs = append(errs, fmt.Errorf("error stopping environment: %w", err))
+	} else if err := ext.controlPlane.release(); err != nil {
+		errs = append(errs, fmt.Errorf("error releasing control plane ports: %w", err))
 	}
 
-	return nil
+	for _, opts := range ext.AllAPIServiceInstallOptions() {
+		if err := opts.Stop(); err != nil {
+			errs = append(errs, fmt.Errorf("error stopping aggregated api server: %w", err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func WaitUntilTypesDiscoverableWithTimeout(timeout time.Duration, c client.Client, objs ...client.Object) error {
@@ -509,7 +855,7 @@ func WaitUntilGroupVersionsDiscoverableWithTimeout(timeout time.Duration, c clie
 }
 
 func WaitUntilAPIServicesReady(ctx context.Context, ext *EnvironmentExtensions, c client.Client, scheme *runtime.Scheme) error {
-	apiServices := ext.APIServiceInstallOptions.APIServices
+	apiServices := ext.AllAPIServices()
 
 	if err := WaitUntilAPIServicesAvailable(ctx, c, apiServices...); err != nil {
 		return fmt.Errorf("error waiting for api services to be available: %w", err)
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package envtestutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	apiregistrationv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resmap"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// IsKustomization reports whether the directory contains a kustomization file.
+func IsKustomization(dir string) bool {
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// KustomizationFiles returns the absolute paths of all files read when building the kustomization in dir,
+// including the ones of its bases and patches outside of dir.
+func KustomizationFiles(dir string) ([]string, error) {
+	fSys := &recordingFileSystem{FileSystem: filesys.MakeFsOnDisk(), files: sets.New[string]()}
+	if _, err := buildKustomization(fSys, dir); err != nil {
+		return nil, err
+	}
+	return sets.List(fSys.files), nil
+}
+
+// recordingFileSystem records the files read from it.
+type recordingFileSystem struct {
+	filesys.FileSystem
+	files sets.Set[string]
+}
+
+func (fs *recordingFileSystem) ReadFile(path string) ([]byte, error) {
+	data, err := fs.FileSystem.ReadFile(path)
+	if err == nil {
+		if absPath, err := filepath.Abs(path); err == nil {
+			fs.files.Insert(absPath)
+		}
+	}
+	return data, err
+}
+
+func buildKustomization(fSys filesys.FileSystem, dir string) (resmap.ResMap, error) {
+	k := krusty.MakeKustomizer(krusty.MakeDefaultOptions())
+	resMap, err := k.Run(fSys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error building kustomization %s: %w", dir, err)
+	}
+	return resMap, nil
+}
+
+// renderKustomization builds the kustomization in dir and returns the APIServices of the rendered output.
+func renderKustomization(dir string) ([]*apiregistrationv1.APIService, error) {
+	resMap, err := buildKustomization(filesys.MakeFsOnDisk(), dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiServices []*apiregistrationv1.APIService
+	for _, res := range resMap.Resources() {
+		if res.GetKind() != "APIService" {
+			continue
+		}
+
+		doc, err := res.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling %s of kustomization %s: %w", res.CurId(), dir, err)
+		}
+		apiService, err := decodeAPIService(doc)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding %s of kustomization %s: %w", res.CurId(), dir, err)
+		}
+		if apiService != nil {
+			apiServices = append(apiServices, apiService)
+		}
+	}
+	return apiServices, nil
+}
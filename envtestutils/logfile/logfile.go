@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package logfile captures the output of processes into rotating log files.
+package logfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	DefaultMaxSize    = 10 * 1024 * 1024
+	DefaultMaxBackups = 3
+	DefaultTailLines  = 200
+)
+
+// Options configure a File.
+type Options struct {
+	// MaxSize is the size in bytes after which the file is rotated. Defaults to DefaultMaxSize.
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept next to the file. Defaults to DefaultMaxBackups.
+	MaxBackups int
+	// TailLines is the number of most recent lines kept in memory for Tail. Defaults to DefaultTailLines.
+	TailLines int
+}
+
+func setOptionsDefaults(opts *Options) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	if opts.MaxBackups < 0 {
+		opts.MaxBackups = 0
+	} else if opts.MaxBackups == 0 {
+		opts.MaxBackups = DefaultMaxBackups
+	}
+	if opts.TailLines <= 0 {
+		opts.TailLines = DefaultTailLines
+	}
+}
+
+// File is a rotating log file capturing the output of a single component.
+// Every line written to it is prefixed with the name of the component.
+type File struct {
+	component string
+	path      string
+	opts      Options
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	tail   []string
+	next   int
+	closed bool
+}
+
+// Open creates the log file <dir>/<component>.log. An existing file is rotated.
+func Open(dir, component string, opts Options) (*File, error) {
+	setOptionsDefaults(&opts)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("error creating log directory: %w", err)
+	}
+
+	f := &File{
+		component: component,
+		path:      filepath.Join(dir, component+".log"),
+		opts:      opts,
+		tail:      make([]string, 0, opts.TailLines),
+	}
+	if _, err := os.Stat(f.path); err == nil {
+		if err := f.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Component returns the name of the component the file captures the output of.
+func (f *File) Component() string {
+	return f.component
+}
+
+// Path returns the path of the current log file.
+func (f *File) Path() string {
+	return f.path
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+	f.file = file
+	f.size = 0
+	return nil
+}
+
+func (f *File) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+// rotate shifts the current file and its backups by one, dropping the oldest backup.
+func (f *File) rotate() error {
+	if f.file != nil {
+		_ = f.file.Close()
+		f.file = nil
+	}
+
+	if f.opts.MaxBackups == 0 {
+		return os.Remove(f.path)
+	}
+	_ = os.Remove(f.backupPath(f.opts.MaxBackups))
+	for i := f.opts.MaxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(f.backupPath(i), f.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error rotating log file: %w", err)
+		}
+	}
+	if err := os.Rename(f.path, f.backupPath(1)); err != nil {
+		return fmt.Errorf("error rotating log file: %w", err)
+	}
+	return nil
+}
+
+func (f *File) writeLine(line []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+
+	prefixed := fmt.Sprintf("[%s] %s\n", f.component, line)
+	if len(f.tail) < f.opts.TailLines {
+		f.tail = append(f.tail, prefixed[:len(prefixed)-1])
+	} else {
+		f.tail[f.next] = prefixed[:len(prefixed)-1]
+		f.next = (f.next + 1) % f.opts.TailLines
+	}
+
+	if f.size > 0 && f.size+int64(len(prefixed)) > f.opts.MaxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	n, err := io.WriteString(f.file, prefixed)
+	f.size += int64(n)
+	return err
+}
+
+// Tail returns up to n of the most recently written lines, oldest first.
+func (f *File) Tail(n int) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lines := make([]string, 0, len(f.tail))
+	lines = append(lines, f.tail[f.next:]...)
+	lines = append(lines, f.tail[:f.next]...)
+	if n >= 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// Close closes the log file. Lines written afterward are discarded.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// Writer returns a writer splitting its input into lines written to the file. Each stream
+// of a process (stdout, stderr) should use its own writer to not interleave partial lines.
+func (f *File) Writer() io.WriteCloser {
+	return &lineWriter{file: f}
+}
+
+type lineWriter struct {
+	file *File
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(w.buf[:idx], []byte("\r"))
+		if err := w.file.writeLine(line); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[idx+1:]
+	}
+	return len(p), nil
+}
+
+// Close writes any remaining partial line.
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := w.buf
+	w.buf = nil
+	return w.file.writeLine(line)
+}
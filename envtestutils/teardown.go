@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2023 SAP SE or an SAP affiliate company and IronCore contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package envtestutils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ironcore-dev/openapi-extractor/internal/testing/addr"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// controlPlaneResources are the temporary directories and ports of the local control plane.
+// envtest removes the directories it generated but keeps the ports reserved until they are outdated.
+type controlPlaneResources struct {
+	generateCertDir bool
+	generateDataDir bool
+
+	dirs  []string
+	ports []int
+	addrs []string
+}
+
+func newControlPlaneResources(env *envtest.Environment) controlPlaneResources {
+	if envUsesExistingCluster(env) {
+		return controlPlaneResources{}
+	}
+	res := controlPlaneResources{
+		generateCertDir: env.ControlPlane.GetAPIServer().CertDir == "",
+	}
+	if env.ControlPlane.Etcd == nil || env.ControlPlane.Etcd.DataDir == "" {
+		res.generateDataDir = true
+	}
+	return res
+}
+
+// record records the resources of the started control plane.
+func (r *controlPlaneResources) record(env *envtest.Environment) {
+	if envUsesExistingCluster(env) {
+		return
+	}
+
+	apiServer := env.ControlPlane.GetAPIServer()
+	if r.generateCertDir && apiServer.CertDir != "" {
+		r.dirs = append(r.dirs, apiServer.CertDir)
+	}
+	r.recordAddr(apiServer.SecureServing.Address, apiServer.SecureServing.Port)
+	if apiServer.InsecureServing != nil {
+		r.recordAddr(apiServer.InsecureServing.Address, apiServer.InsecureServing.Port)
+	}
+
+	if etcd := env.ControlPlane.Etcd; etcd != nil {
+		if r.generateDataDir && etcd.DataDir != "" {
+			r.dirs = append(r.dirs, etcd.DataDir)
+		}
+		if etcd.URL != nil {
+			r.recordAddr(etcd.URL.Hostname(), etcd.URL.Port())
+		}
+	}
+}
+
+func (r *controlPlaneResources) recordAddr(host, port string) {
+	if port == "" {
+		return
+	}
+	r.addrs = append(r.addrs, net.JoinHostPort(host, port))
+	if p, err := strconv.Atoi(port); err == nil {
+		r.ports = append(r.ports, p)
+	}
+}
+
+// release releases the ports of the stopped control plane.
+func (r *controlPlaneResources) release() error {
+	var errs []error
+	for _, port := range r.ports {
+		if err := addr.Release(port); err != nil {
+			errs = append(errs, fmt.Errorf("error releasing port %d: %w", port, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// VerifyStopped reports the temporary directories, port lock files and listening processes of the
+// control plane and the aggregated api servers that are left behind after StopWithExtensions.
+func VerifyStopped(ext *EnvironmentExtensions) error {
+	var (
+		dirs      = append([]string(nil), ext.controlPlane.dirs...)
+		portFiles []string
+		addrs     = append([]string(nil), ext.controlPlane.addrs...)
+	)
+	for _, port := range ext.controlPlane.ports {
+		portFiles = append(portFiles, addr.PortFile(port))
+	}
+	for _, opts := range ext.AllAPIServiceInstallOptions() {
+		dirs = append(dirs, opts.tempDirs...)
+		for _, port := range opts.ports {
+			portFiles = append(portFiles, addr.PortFile(port))
+		}
+		if opts.LocalServingPort != 0 {
+			addrs = append(addrs, net.JoinHostPort(opts.LocalServingHost, strconv.Itoa(opts.LocalServingPort)))
+		}
+	}
+
+	var leaks []string
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err == nil {
+			leaks = append(leaks, fmt.Sprintf("directory %s", dir))
+		}
+	}
+	for _, file := range portFiles {
+		if _, err := os.Stat(file); err == nil {
+			leaks = append(leaks, fmt.Sprintf("port lock file %s", file))
+		}
+	}
+	for _, address := range addrs {
+		if conn, err := net.DialTimeout("tcp", address, 1*time.Second); err == nil {
+			_ = conn.Close()
+			leaks = append(leaks, fmt.Sprintf("process listening on %s", address))
+		}
+	}
+	if len(leaks) > 0 {
+		return fmt.Errorf("left behind after stopping: %s", strings.Join(leaks, ", "))
+	}
+	return nil
+}
@@ -16,7 +16,7 @@ IRONCORE_PATH=$(go list -f '{{.Dir}}' -m github.com/ironcore-dev/ironcore)
 
 echo "Extract openapi specs from api-server:"
 ./bin/openapi-extractor --apiserver-package=github.com/ironcore-dev/ironcore/cmd/ironcore-apiserver \
-  --apiserver-build-opts=mod \
+  --apiserver-build-mod=mod \
   --apiservices="$IRONCORE_PATH/config/apiserver/apiservice/bases"
 
 echo "Ensure api specs where extracted:"
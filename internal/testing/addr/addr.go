@@ -77,7 +77,7 @@ func (c *portCache) add(port int) (bool, error) {
 		return false, err
 	}
 	// Try allocating new port, by acquiring a file.
-	path := fmt.Sprintf("%s/%s%d", cacheDir, portFilePrefix, port)
+	path := PortFile(port)
 	if err := flock2.Acquire(path); errors.Is(err, flock2.ErrAlreadyLocked) {
 		return false, nil
 	} else if err != nil {
@@ -88,6 +88,20 @@ func (c *portCache) add(port int) (bool, error) {
 
 var cache = &portCache{}
 
+// PortFile returns the path of the lock file reserving port.
+func PortFile(port int) string {
+	return fmt.Sprintf("%s/%s%d", cacheDir, portFilePrefix, port)
+}
+
+// Release removes the lock file reserving port, so that it does not linger in the
+// cache directory until it is outdated. It must only be called once the port is no longer used.
+func Release(port int) error {
+	if err := os.Remove(PortFile(port)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
 func suggest(listenHost string) (*net.TCPListener, int, string, error) {
 	if listenHost == "" {
 		listenHost = "localhost"